@@ -0,0 +1,350 @@
+package browser
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type credential struct {
+	user, pass string
+}
+
+// A challenge the browser has answered for a host, reused for later requests
+type authChallenge struct {
+	scheme string
+	params map[string]string
+	cred   credential
+	nc     int
+}
+
+/*
+	Credentials, bearer tokens and answered challenges for a browser.
+	All are keyed by origin (scheme and host) so nothing is sent to
+	another site, or over http after being given for https. The realm a
+	server names only picks between the credentials for its own origin.
+*/
+type authState struct {
+	mutex         sync.Mutex
+	credentials   map[string]map[string]credential
+	tokens        map[string]string
+	challenges    map[string]*authChallenge
+	basicOverHttp bool
+}
+
+func newAuthState() *authState {
+	a := authState{}
+	a.reset()
+	return &a
+}
+
+func (a *authState) reset() {
+	a.credentials = make(map[string]map[string]credential)
+	a.tokens = make(map[string]string)
+	a.challenges = make(map[string]*authChallenge)
+}
+
+/*
+	Answer Basic and Digest challenges from a site with a user name and
+	password. The site is an origin such as "https://intranet.example.com",
+	a bare host means https.
+	Example: b.SetCredentials("intranet.example.com", "user", "secret")
+*/
+func (b *Browser) SetCredentials(site, user, pass string) {
+	b.SetRealmCredentials(site, "", user, pass)
+}
+
+/*
+	Like SetCredentials but only for challenges naming this realm,
+	for sites which ask for different logins in different places
+	Example: b.SetRealmCredentials("https://example.com", "admin", "root", "secret")
+*/
+func (b *Browser) SetRealmCredentials(site, realm, user, pass string) {
+	b.auth.mutex.Lock()
+	defer b.auth.mutex.Unlock()
+
+	key := siteOrigin(site)
+	if b.auth.credentials[key] == nil {
+		b.auth.credentials[key] = make(map[string]credential)
+	}
+	b.auth.credentials[key][realm] = credential{user, pass}
+}
+
+/*
+	Allow Basic auth over plain http, where anyone watching can read the
+	password. Off by default, Digest is still answered over http.
+*/
+func (b *Browser) SetBasicOverHttp(on bool) {
+	b.auth.mutex.Lock()
+	defer b.auth.mutex.Unlock()
+
+	b.auth.basicOverHttp = on
+}
+
+/*
+	Send a bearer token with every request to a site, given as an origin
+	or a bare host which means https. It is never sent to another origin,
+	including on a redirect to the same host over http.
+	Example: b.SetBearerToken("api.example.com", token)
+*/
+func (b *Browser) SetBearerToken(site, token string) {
+	b.auth.mutex.Lock()
+	defer b.auth.mutex.Unlock()
+
+	b.auth.tokens[siteOrigin(site)] = token
+}
+
+// Forget all credentials, tokens and answered challenges
+func (b *Browser) ClearCredentials() {
+	b.auth.mutex.Lock()
+	defer b.auth.mutex.Unlock()
+
+	b.auth.reset()
+}
+
+// Does the browser have anything to authorize a request to this url with
+func (a *authState) has(u *url.URL) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.token(u) != "" || a.challenge(u) != nil
+}
+
+/*
+	Add an Authorization header to a request when the browser holds a
+	token or an answered challenge for its origin
+*/
+func (a *authState) authorize(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if c := a.challenge(req.URL); c != nil {
+		req.Header.Set("Authorization", c.answer(req))
+	} else if token := a.token(req.URL); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+/*
+	Remember the challenge in a 401 response if the browser has
+	credentials for it, returns true if the request is worth retrying
+*/
+func (a *authState) answerChallenge(resp *http.Response) bool {
+	u := resp.Request.URL
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var basic *authChallenge
+	for _, header := range resp.Header.Values("Www-Authenticate") {
+		for _, c := range parseChallenges(header) {
+			cred, ok := a.credential(u, c.params["realm"])
+			if !ok {
+				continue
+			}
+			c.cred = cred
+
+			switch c.scheme {
+			case "digest":
+				if digestHash(c.params["algorithm"]) == nil {
+					continue
+				}
+				// Digest is preferred as it never sends the password
+				a.challenges[origin(u)] = c
+				return true
+			case "basic":
+				if u.Scheme != "https" && !a.basicOverHttp {
+					continue
+				}
+				if basic == nil {
+					basic = c
+				}
+			}
+		}
+	}
+
+	if basic != nil {
+		a.challenges[origin(u)] = basic
+		return true
+	}
+	return false
+}
+
+func (a *authState) token(u *url.URL) string {
+	return a.tokens[origin(u)]
+}
+
+func (a *authState) challenge(u *url.URL) *authChallenge {
+	return a.challenges[origin(u)]
+}
+
+// Credentials for the realm at this origin, or for any realm there
+func (a *authState) credential(u *url.URL, realm string) (credential, bool) {
+	creds := a.credentials[origin(u)]
+	if cred, ok := creds[realm]; ok {
+		return cred, true
+	}
+	cred, ok := creds[""]
+	return cred, ok
+}
+
+// The scheme and host of a url, without a default port
+func origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if (scheme == "https" && strings.HasSuffix(host, ":443")) || (scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndexByte(host, ':')]
+	}
+	return scheme + "://" + host
+}
+
+// The origin of a site given as a url or a bare host, which means https
+func siteOrigin(site string) string {
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	u, err := url.Parse(site)
+	if err != nil {
+		return site
+	}
+	return origin(u)
+}
+
+// Build the Authorization header value for a request
+func (c *authChallenge) answer(req *http.Request) string {
+	if c.scheme == "basic" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.cred.user+":"+c.cred.pass))
+	}
+
+	algorithm := c.params["algorithm"]
+	h := func(s string) string {
+		d := digestHash(algorithm)
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	cnonce := newCnonce()
+	realm, nonce := c.params["realm"], c.params["nonce"]
+	uri := req.URL.RequestURI()
+
+	ha1 := h(c.cred.user + ":" + realm + ":" + c.cred.pass)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	qop := ""
+	for _, q := range strings.Split(c.params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	ret := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		c.cred.user, realm, nonce, uri, response)
+	if algorithm != "" {
+		ret += ", algorithm=" + algorithm
+	}
+	if opaque, ok := c.params["opaque"]; ok {
+		ret += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	if qop != "" {
+		ret += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	return ret
+}
+
+// Return a new hash for a digest algorithm or nil if it isn't supported
+func digestHash(algorithm string) hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New()
+	case "SHA-256":
+		return sha256.New()
+	}
+	return nil
+}
+
+func newCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+	Split a WWW-Authenticate header into its challenges
+	Example: `Digest realm="x", nonce="y", Basic realm="x"` gives two challenges
+*/
+func parseChallenges(header string) []*authChallenge {
+	challenges := []*authChallenge{}
+	var current *authChallenge
+
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+
+		token := s
+		if i := strings.IndexAny(s, " \t,="); i >= 0 {
+			token = s[:i]
+		}
+		s = strings.TrimLeft(s[len(token):], " \t")
+
+		if !strings.HasPrefix(s, "=") {
+			// A bare token starts a new challenge
+			current = &authChallenge{scheme: strings.ToLower(token), params: map[string]string{}}
+			challenges = append(challenges, current)
+			continue
+		}
+
+		// key=value or key="quoted value"
+		s = strings.TrimLeft(s[1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.ReplaceAll(s[1:min(end, len(s))], `\"`, `"`)
+			s = s[min(end+1, len(s)):]
+		} else {
+			end := strings.IndexAny(s, ", \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+
+		if current != nil {
+			current.params[strings.ToLower(token)] = value
+		}
+	}
+
+	return challenges
+}
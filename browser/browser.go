@@ -7,12 +7,14 @@ package browser
 import (
 	"fmt"
 	"github.com/tlowry/grawl/element"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 )
 
 const (
@@ -20,9 +22,20 @@ const (
 )
 
 type Browser struct {
-	Client    *http.Client
-	url       string
-	userAgent string
+	Client         *http.Client
+	url            string
+	userAgent      string
+	headers        http.Header
+	autoReferer    bool
+	proxy          ProxySelector
+	auth           *authState
+	redirect       func(req *http.Request, via []*http.Request) error
+	redirectPolicy *RedirectPolicy
+	logger         *slog.Logger
+	requestHooks   []RequestHook
+	responseHooks  []ResponseHook
+	errorHooks     []ErrorHook
+	mutex          sync.RWMutex
 }
 
 // Create a new Grawl Browser
@@ -38,30 +51,124 @@ func NewBrowser() *Browser {
 	}
 
 	b.userAgent = UAgent
+	b.headers = defaultHeaders()
+	b.autoReferer = true
+	b.auth = newAuthState()
+	b.redirectPolicy = NewRedirectPolicy()
+	b.logger = discardLogger()
+	b.Client.CheckRedirect = b.checkRedirect
 
 	return &b
 }
 
-// Create a grawl browser using a predefined http client
+/*
+	Create a grawl browser using a predefined http client. The browser
+	works on a shallow copy so the client, which may be shared or
+	http.DefaultClient, keeps its own redirect handling.
+*/
 func NewBrowserWithClient(client *http.Client) *Browser {
 	b := Browser{}
-	b.Client = client
+	c := *client
+	b.Client = &c
+	b.headers = http.Header{}
+	b.autoReferer = true
+	b.auth = newAuthState()
+	b.redirectPolicy = NewRedirectPolicy()
+	b.logger = discardLogger()
+
+	// Keep the clients own redirect policy but let the browser see each hop
+	b.redirect = client.CheckRedirect
+	b.Client.CheckRedirect = b.checkRedirect
 
 	return &b
 }
 
 // Return the user agent the browser is currently using in requests
 func (b *Browser) GetUserAgent() string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
 	return b.userAgent
 }
 
 // Set the user agent to be used in browser requests
 func (b *Browser) SetUserAgent(agent string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	b.userAgent = agent
 }
 
+/*
+	Send all requests through a single http or socks5 proxy
+	Example: b.SetProxy("socks5://127.0.0.1:1080")
+*/
+func (b *Browser) SetProxy(proxyUrl string) {
+	proxy, err := NewStaticProxy(proxyUrl)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid proxy %s", err.Error()))
+	}
+	b.SetProxySelector(proxy)
+}
+
+// Use the proxy given by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+func (b *Browser) SetProxyFromEnvironment() {
+	b.SetProxySelector(EnvProxy{})
+}
+
+/*
+	Rotate through a pool of proxies, one per request. Proxies which keep
+	failing are taken out of rotation, the returned pool can be used to
+	tune or inspect this.
+	Example: b.SetProxyPool("http://10.0.0.1:3128", "socks5://10.0.0.2:1080")
+*/
+func (b *Browser) SetProxyPool(proxyUrls ...string) *ProxyPool {
+	pool, err := NewProxyPool(proxyUrls...)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid proxy %s", err.Error()))
+	}
+	b.SetProxySelector(pool)
+	return pool
+}
+
+/*
+	Choose the proxy for each request using a custom ProxySelector,
+	nil turns proxying off
+*/
+func (b *Browser) SetProxySelector(selector ProxySelector) {
+	b.proxy = selector
+	if selector == nil {
+		return
+	}
+
+	switch t := b.Client.Transport.(type) {
+	case nil:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxyFromRequest
+		b.Client.Transport = transport
+	case *http.Transport:
+		// Work on a copy, the transport may be shared with other clients
+		transport := t.Clone()
+		transport.Proxy = proxyFromRequest
+		b.Client.Transport = transport
+	default:
+		panic("Proxies need the browsers client to use a *http.Transport")
+	}
+}
+
+// Return the ProxySelector in use or nil if requests are sent directly
+func (b *Browser) GetProxySelector() ProxySelector {
+	return b.proxy
+}
+
 // Post a form to the site this browser is connected to
 func (b *Browser) SubmitForm(form *element.Form) *element.Page {
+	return b.SubmitFormWithHeaders(form, nil)
+}
+
+/*
+	Post a form with extra headers which override the browsers defaults
+	for this request only
+*/
+func (b *Browser) SubmitFormWithHeaders(form *element.Form, headers http.Header) *element.Page {
 
 	method := form.Method()
 
@@ -88,9 +195,13 @@ func (b *Browser) SubmitForm(form *element.Form) *element.Page {
 		}
 	}
 
-	for x := range vals {
-		fmt.Println(x, "=", vals[x])
+	// Only the names, values may hold credentials
+	fields := make([]string, 0, len(vals))
+	for name := range vals {
+		fields = append(fields, name)
 	}
+	sort.Strings(fields)
+	b.logger.Debug("submitting form", "name", form.Name(), "fields", fields)
 
 	// build the request using the method, action and form values
 	var resp *http.Response
@@ -106,58 +217,150 @@ func (b *Browser) SubmitForm(form *element.Form) *element.Page {
 	var req *http.Request
 	var err error
 	if body == nil {
-		req, err = http.NewRequest(strings.ToUpper(method), action, nil)
+		req, err = b.newRequest(strings.ToUpper(method), action, nil, headers)
 	} else {
-		req, err = http.NewRequest(strings.ToUpper(method), action, body)
+		if headers.Get("Content-Type") == "" {
+			headers = headers.Clone()
+			if headers == nil {
+				headers = http.Header{}
+			}
+			headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req, err = b.newRequest(strings.ToUpper(method), action, body, headers)
 	}
 
 	if err != nil {
 		panic(err)
 	}
 
-	req.Header.Set("User-Agent", b.GetUserAgent())
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	log.Println("Sending request " + action)
-	resp, err = b.Client.Do(req)
-	log.Println("Request sent")
+	resp, err = b.do(req)
 	if err != nil {
 		panic(err)
 	}
 
-	page := element.ParseResp(resp)
-	log.Println("parsed")
+	page, err := b.toPage(resp)
+	if err != nil {
+		panic(err)
+	}
 	return page
 
 }
 
 // Load a page from a url
 func (b *Browser) Load(url string) *element.Page {
-	b.url = url
+	return b.LoadWithHeaders(url, nil)
+}
+
+/*
+	Load a page with extra headers which override the browsers defaults
+	for this request only
+	Example: b.LoadWithHeaders(url, http.Header{"Accept-Language": {"fr"}})
+*/
+func (b *Browser) LoadWithHeaders(url string, headers http.Header) *element.Page {
 	// Fills in "http://" if the url is missing the protocol
-	url = FixProtocol(url)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := b.newRequest("GET", FixProtocol(url), nil, headers)
 	if err != nil {
 		panic(fmt.Sprintf("Error creating request %s", err.Error()))
 	}
 
-	req.Header.Set("User-Agent", b.GetUserAgent())
-
 	var resp *http.Response
-	resp, err = b.Client.Do(req)
+	resp, err = b.do(req)
 	if err != nil {
 		panic(fmt.Sprintf("Error submitting request %s", err.Error()))
 	}
 
-	page := element.ParseResp(resp)
-	page.SetUrl(url)
-
+	// The browser is now wherever the redirects ended up
+	page, err := b.toPage(resp)
+	if err != nil {
+		panic(err)
+	}
 	return page
 }
 
+/*
+	Fetch a url without parsing it as a page, for feeds, files and apis.
+	The browsers cookies, headers, proxies and hooks all apply but the
+	browser stays on its current page. The caller must close the body.
+	Example:
+	resp, err := b.Fetch("https://example.com/data.csv", nil)
+*/
+func (b *Browser) Fetch(url string, headers http.Header) (*http.Response, error) {
+	req, err := b.newRequest("GET", FixProtocol(url), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	return b.do(req)
+}
+
+/*
+	Send a request through the browsers client, answering any
+	Basic or Digest challenge the server responds with
+*/
+func (b *Browser) do(req *http.Request) (*http.Response, error) {
+	b.auth.authorize(req)
+
+	resp, err := b.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !b.auth.answerChallenge(resp) {
+		return resp, err
+	}
+
+	// Retry once now the challenge has been answered
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			// Can't replay the body, hand back the 401
+			return resp, nil
+		}
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+
+	retry.Header.Del("Authorization")
+	b.auth.authorize(retry)
+	return b.send(retry)
+}
+
+/*
+	Send a request through the browsers client, picking a proxy for it
+	first and reporting back how that proxy did
+*/
+func (b *Browser) send(req *http.Request) (*http.Response, error) {
+	var proxy *url.URL
+	if b.proxy != nil {
+		var err error
+		proxy, err = b.proxy.Proxy(req)
+		if err != nil {
+			b.onError(req, err)
+			return nil, err
+		}
+		req = withProxy(req, proxy)
+	}
+
+	req = withClock(req)
+	b.onRequest(req)
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		b.onError(req, err)
+	} else {
+		b.onResponse(resp, lapHop(req.Context()))
+	}
+
+	if proxy != nil {
+		if err == nil && resp.StatusCode == http.StatusProxyAuthRequired {
+			b.proxy.Report(proxy, fmt.Errorf("proxy %s refused the request: %s", proxy.Host, resp.Status))
+		} else {
+			b.proxy.Report(proxy, err)
+		}
+	}
+	return resp, err
+}
+
 // Load a page from a local file
 func (b *Browser) LoadFile(fileName string) *element.Page {
-	b.url = fileName
+	b.setUrl(fileName)
 
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -172,6 +375,15 @@ func (b *Browser) LoadFile(fileName string) *element.Page {
 
 // Convert a relative url to an absolute url based on the browsers currently loaded page url
 func (b *Browser) RelToAbs(relUrl string) string {
+	currentUrl := b.getUrl()
+
+	// Resolve properly once the browser has been to a real url
+	if base, err := url.Parse(currentUrl); err == nil && base.IsAbs() {
+		if ref, err := url.Parse(relUrl); err == nil {
+			return base.ResolveReference(ref).String()
+		}
+	}
+
 	protoAndURL := strings.Split(relUrl, "://")
 
 	fixedUrl := relUrl
@@ -179,10 +391,10 @@ func (b *Browser) RelToAbs(relUrl string) string {
 	if len(protoAndURL) < 2 {
 
 		// No protocol, remove excess / if required and concatenate
-		if strings.HasPrefix(relUrl, "/") && strings.HasSuffix(b.url, "/") {
-			fixedUrl = b.url + relUrl[1:len(relUrl)]
+		if strings.HasPrefix(relUrl, "/") && strings.HasSuffix(currentUrl, "/") {
+			fixedUrl = currentUrl + relUrl[1:len(relUrl)]
 		} else {
-			fixedUrl = b.url + relUrl
+			fixedUrl = currentUrl + relUrl
 		}
 
 	}
@@ -190,7 +402,7 @@ func (b *Browser) RelToAbs(relUrl string) string {
 }
 
 func (b *Browser) GetCookies() []*http.Cookie {
-	currentURL, _ := url.Parse(b.url)
+	currentURL, _ := url.Parse(b.getUrl())
 	return b.Client.Jar.Cookies(currentURL)
 }
 
@@ -199,14 +411,27 @@ func (b *Browser) ClearCookies() {
 }
 
 func (b *Browser) SetCookie(cookie *http.Cookie) {
-	currentURL, _ := url.Parse(b.url)
+	// Hold the lock so currentURL can't change part way through
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
-	// ToDo lock here in case currentURL changes
+	currentURL, _ := url.Parse(b.url)
 	cookies := b.Client.Jar.Cookies(currentURL)
 	cookies = append(cookies, cookie)
 	b.Client.Jar.SetCookies(currentURL, cookies)
-	//ToDo unlock here
+}
 
+// The url of the page the browser is currently on
+func (b *Browser) getUrl() string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.url
+}
+
+func (b *Browser) setUrl(url string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.url = url
 }
 
 // prepends http:// to the start of urls which are missing a protocol
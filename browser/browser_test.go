@@ -1,7 +1,878 @@
 package browser
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tlowry/grawl/element"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestBrowser(t *testing.T) {
 
 }
+
+func TestProxyOnSharedTransport(t *testing.T) {
+	shared := &http.Transport{}
+	b := NewBrowserWithClient(&http.Client{Transport: shared})
+	b.SetProxy("http://10.0.0.1:3128")
+	if shared.Proxy != nil {
+		t.Fatal("expected the callers transport to be left alone")
+	}
+	if transport, ok := b.Client.Transport.(*http.Transport); !ok || transport == shared || transport.Proxy == nil {
+		t.Fatalf("expected the browser to proxy through its own copy, got %v", b.Client.Transport)
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/next", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer server.Close()
+
+	shared := &http.Client{}
+	first, second := NewBrowserWithClient(shared), NewBrowserWithClient(shared)
+	if shared.CheckRedirect != nil {
+		t.Fatal("expected the callers client to be left alone")
+	}
+
+	requests := 0
+	second.OnRequest(RequestHookFunc(func(req *http.Request) { requests++ }))
+	first.Load(server.URL)
+	second.Load(server.URL)
+	if requests != 2 {
+		t.Fatalf("expected each browser to run only its own hooks once per hop, got %d", requests)
+	}
+}
+
+func TestProxyPoolRotation(t *testing.T) {
+	pool, err := NewProxyPool("http://10.0.0.1:3128", "socks5://10.0.0.2:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MaxFailures = 2
+
+	first, _ := pool.Proxy(nil)
+	second, _ := pool.Proxy(nil)
+	if first.String() == second.String() {
+		t.Fatalf("expected rotation, got %s twice", first)
+	}
+
+	pool.Report(first, errors.New("refused"))
+	pool.Report(first, errors.New("refused"))
+
+	for i := 0; i < 3; i++ {
+		p, err := pool.Proxy(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != second.String() {
+			t.Fatalf("failed proxy %s still in rotation", p)
+		}
+	}
+
+	pool.Report(second, errors.New("refused"))
+	pool.Report(second, errors.New("refused"))
+	if _, err := pool.Proxy(nil); err != ErrNoProxy {
+		t.Fatalf("expected ErrNoProxy, got %v", err)
+	}
+}
+
+func TestProxyPoolBadScheme(t *testing.T) {
+	if _, err := NewProxyPool("ftp://10.0.0.1"); err == nil {
+		t.Fatal("expected an error for an ftp proxy")
+	}
+}
+
+func TestLoadThroughProxy(t *testing.T) {
+	seen := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.String()
+		fmt.Fprint(w, "<html><body><p id=\"x\">proxied</p></body></html>")
+	}))
+	defer proxy.Close()
+
+	b := NewBrowser()
+	pool := b.SetProxyPool(proxy.URL)
+
+	page := b.Load("http://example.invalid/page")
+	if seen != "http://example.invalid/page" {
+		t.Fatalf("request did not go through the proxy, saw %q", seen)
+	}
+	if page.ById("x").GetContent() != "proxied" {
+		t.Fatal("unexpected page content")
+	}
+	if len(pool.Healthy()) != 1 {
+		t.Fatal("working proxy was taken out of rotation")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var last *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		fmt.Fprint(w, `<html><body><form id="f" action="/submit"><input name="q" value="x"></form></body></html>`)
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.SetHeaderProfile(GetHeaderProfile("firefox"))
+	b.SetHeader("X-Test", "default")
+
+	page := b.LoadWithHeaders(server.URL+"/", http.Header{"x-test": {"override"}})
+	if last.Header.Get("User-Agent") != ProfileFirefoxDesktop.UserAgent {
+		t.Fatalf("profile user agent not sent, got %q", last.Header.Get("User-Agent"))
+	}
+	if last.Header.Get("X-Test") != "override" {
+		t.Fatalf("per request header did not override default, got %q", last.Header.Get("X-Test"))
+	}
+	if last.Header.Get("Referer") != "" {
+		t.Fatal("first request should not carry a referer")
+	}
+
+	b.SubmitForm(page.ById("f").(*element.Form))
+	if last.Header.Get("Referer") != server.URL+"/" {
+		t.Fatalf("expected referer %s, got %q", server.URL+"/", last.Header.Get("Referer"))
+	}
+	if last.Header.Get("Origin") != server.URL {
+		t.Fatalf("expected origin %s, got %q", server.URL, last.Header.Get("Origin"))
+	}
+	if last.Header.Get("X-Test") != "default" {
+		t.Fatal("default header not sent")
+	}
+}
+
+func TestRefererAndFetchSite(t *testing.T) {
+	var last *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		fmt.Fprint(w, `<html><p>ok</p></html>`)
+	}))
+	defer server.Close()
+
+	path := t.TempDir() + "/saved.html"
+	if err := os.WriteFile(path, []byte(`<html><p>saved</p></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBrowser()
+	b.SetHeaderProfile(ProfileChromeDesktop)
+	b.LoadFile(path)
+	b.Load(server.URL + "/a")
+	if last.Header.Get("Referer") != "" || last.Header.Get("Sec-Fetch-Site") != "none" {
+		t.Fatalf("a file should not be sent as the referer, got %q %q", last.Header.Get("Referer"), last.Header.Get("Sec-Fetch-Site"))
+	}
+	b.Load(server.URL + "/b")
+	if last.Header.Get("Sec-Fetch-Site") != "same-origin" {
+		t.Errorf("expected same-origin, got %q", last.Header.Get("Sec-Fetch-Site"))
+	}
+
+	referers := [][3]string{
+		{"https://example.com/a?token=1#top", "https://example.com/b", "https://example.com/a?token=1"},
+		{"https://example.com/a?token=1", "https://other.org/", "https://example.com/"},
+		{"https://example.com/a?token=1", "http://example.com/b", ""},
+		{"http://example.com/a?q=1", "https://example.com/b", "http://example.com/"},
+	}
+	for _, c := range referers {
+		from, _ := url.Parse(c[0])
+		to, _ := url.Parse(c[1])
+		if got := refererFor(from, to); got != c[2] {
+			t.Errorf("%s to %s: expected referer %q, got %q", c[0], c[1], c[2], got)
+		}
+	}
+
+	b.SetHeaderProfile(nil)
+	if b.GetUserAgent() != UAgent || b.GetHeaders().Get("Accept-Language") == "" {
+		t.Errorf("expected a nil profile to restore the defaults, got %q %v", b.GetUserAgent(), b.GetHeaders())
+	}
+
+	cases := [][3]string{
+		{"https://www.example.com/a", "https://shop.example.com/", "same-site"},
+		{"https://example.com/", "https://other.org/", "cross-site"},
+		{"https://one.co.uk/", "https://two.co.uk/", "cross-site"},
+		{"http://www.example.com/", "https://www.example.com/", "cross-site"},
+	}
+	for _, c := range cases {
+		from, _ := url.Parse(c[0])
+		to, _ := url.Parse(c[1])
+		if got := fetchSite(from, to); got != c[2] {
+			t.Errorf("%s to %s: expected %s, got %s", c[0], c[1], c[2], got)
+		}
+	}
+}
+
+func TestBasicAuthChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="intranet"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `<html><p id="x">in</p></html>`)
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.SetRealmCredentials(server.URL, "intranet", "user", "secret")
+	if b.Load(server.URL).Document.StatusCode != http.StatusUnauthorized {
+		t.Fatal("basic should not be sent over http without opting in")
+	}
+	b.SetBasicOverHttp(true)
+	if b.Load(server.URL).ById("x") == nil {
+		t.Fatal("basic challenge was not answered")
+	}
+
+	// A realm named by another site doesn't get the password
+	other := NewBrowser()
+	other.SetBasicOverHttp(true)
+	other.SetRealmCredentials("http://intranet.example.com", "intranet", "user", "secret")
+	if other.Load(server.URL).Document.StatusCode != http.StatusUnauthorized {
+		t.Fatal("credentials were sent to a host by realm alone")
+	}
+
+	b.ClearCredentials()
+	if b.Load(server.URL).Document.StatusCode != http.StatusUnauthorized {
+		t.Fatal("credentials were kept after ClearCredentials")
+	}
+}
+
+func TestDigestAuthChallenge(t *testing.T) {
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := parseChallenges(r.Header.Get("Authorization"))
+		if len(c) == 1 && c[0].scheme == "digest" {
+			p := c[0].params
+			ha1 := h("user:files:secret")
+			ha2 := h(r.Method + ":" + p["uri"])
+			if p["response"] == h(ha1+":abc:"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2) && p["opaque"] == "xyz" {
+				fmt.Fprint(w, `<html><p id="x">in</p></html>`)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Digest realm="files", qop="auth,auth-int", nonce="abc", opaque="xyz"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.SetCredentials(server.URL, "user", "secret")
+	if b.Load(server.URL+"/a?b=c").ById("x") == nil {
+		t.Fatal("digest challenge was not answered")
+	}
+}
+
+func TestBearerTokenScopedToHost(t *testing.T) {
+	leaked := ""
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer other.Close()
+
+	sent := ""
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("Authorization")
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer api.Close()
+
+	b := NewBrowser()
+	b.SetBearerToken(api.URL, "token")
+	b.Load(api.URL)
+
+	if sent != "Bearer token" {
+		t.Fatalf("token not sent to its host, got %q", sent)
+	}
+	if leaked != "" {
+		t.Fatalf("token leaked on redirect: %q", leaked)
+	}
+
+	// The same host over plain http is another origin
+	var secure *httptest.Server
+	secure = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(secure.URL, "https://", "http://", 1)+"/plain", http.StatusFound)
+	}))
+	// The plain http request to the tls port fails, which is expected
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	defer secure.Close()
+
+	b = NewBrowserWithClient(secure.Client())
+	b.SetBearerToken(strings.TrimPrefix(secure.URL, "https://"), "token")
+	sentTo := map[string]string{}
+	b.OnRequest(RequestHookFunc(func(req *http.Request) {
+		sentTo[req.URL.Scheme] = req.Header.Get("Authorization")
+	}))
+	func() {
+		defer func() { recover() }()
+		b.Load(secure.URL)
+	}()
+	if sentTo["https"] != "Bearer token" {
+		t.Fatalf("token not sent over https, got %q", sentTo["https"])
+	}
+	if auth, ok := sentTo["http"]; !ok || auth != "" {
+		t.Fatalf("expected the http hop without a token, got %q %v", auth, ok)
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			fmt.Fprint(w, `<html><head><meta http-equiv="Refresh" content="0; URL='/c'"></head></html>`)
+		case "/c":
+			http.Redirect(w, r, "/d", http.StatusFound)
+		default:
+			fmt.Fprint(w, `<html><p id="x">done</p></html>`)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.GetRedirectPolicy().FollowMetaRefresh = true
+
+	page := b.Load(server.URL + "/a")
+	if page.GetUrl() != server.URL+"/d" {
+		t.Fatalf("expected to end at /d, got %s", page.GetUrl())
+	}
+	if b.RelToAbs("e") != server.URL+"/e" {
+		t.Fatalf("browser url not updated, resolved to %s", b.RelToAbs("e"))
+	}
+
+	chain := page.GetRedirects()
+	want := []element.Redirect{
+		{From: server.URL + "/a", To: server.URL + "/b", StatusCode: http.StatusMovedPermanently, Kind: element.REDIRECT_HTTP},
+		{From: server.URL + "/b", To: server.URL + "/c", StatusCode: http.StatusOK, Kind: element.REDIRECT_META_REFRESH},
+		{From: server.URL + "/c", To: server.URL + "/d", StatusCode: http.StatusFound, Kind: element.REDIRECT_HTTP},
+	}
+	if len(chain) != len(want) {
+		t.Fatalf("expected %d hops, got %v", len(want), chain)
+	}
+	for i := range want {
+		if chain[i] != want[i] {
+			t.Fatalf("hop %d: expected %v, got %v", i, want[i], chain[i])
+		}
+	}
+
+	policy := NewRedirectPolicy()
+	policy.MaxHops = 1
+	policy.UseLastResponse = true
+	b.SetRedirectPolicy(policy)
+	page = b.Load(server.URL + "/a")
+	if page.Document.StatusCode != http.StatusOK || len(page.GetRedirects()) != 1 {
+		t.Fatalf("meta refresh should not be followed, got %v", page.GetRedirects())
+	}
+
+	policy.Approve = func(hop element.Redirect) bool { return false }
+	page = b.Load(server.URL + "/a")
+	if page.Document.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("refused redirect should hand back the redirect, got %d", page.Document.StatusCode)
+	}
+}
+
+func TestMetaRefreshLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			fmt.Fprint(w, `<html><head><meta http-equiv="refresh" content="600;url=/logout"></head></html>`)
+		case "/script":
+			fmt.Fprint(w, `<html><head><meta http-equiv="refresh" content="0;url=javascript:alert(1)"></head></html>`)
+		default:
+			t.Errorf("unexpected request for %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.GetRedirectPolicy().FollowMetaRefresh = true
+	if page := b.Load(server.URL + "/slow"); page.GetUrl() != server.URL+"/slow" {
+		t.Errorf("a slow refresh should be left alone, went to %s", page.GetUrl())
+	}
+	if page := b.Load(server.URL + "/script"); page.GetUrl() != server.URL+"/script" {
+		t.Errorf("a javascript: refresh should be refused, went to %s", page.GetUrl())
+	}
+}
+
+type countingHook struct {
+	requests, responses, errors int
+}
+
+func (c *countingHook) OnRequest(req *http.Request) {
+	c.requests++
+	req.Header.Set("X-Trace", "1")
+}
+
+func (c *countingHook) OnResponse(resp *http.Response, elapsed time.Duration) {
+	c.responses++
+}
+
+func (c *countingHook) OnError(req *http.Request, err error) {
+	c.errors++
+}
+
+func TestHooksAndLogging(t *testing.T) {
+	trace := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = r.Header.Get("X-Trace")
+		fmt.Fprint(w, `<html><form id="f" method=""><input name="password" value="hunter2"></form></html>`)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	b := NewBrowser()
+	b.SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	hook := &countingHook{}
+	b.OnRequest(hook)
+	b.OnResponse(hook)
+	b.OnError(hook)
+
+	page := b.Load(server.URL)
+	b.SubmitForm(page.ById("f").(*element.Form))
+
+	if hook.requests != 2 || hook.responses != 2 || hook.errors != 0 {
+		t.Fatalf("unexpected hook counts %+v", hook)
+	}
+	if trace != "1" {
+		t.Fatal("request hook could not add a header")
+	}
+	if !strings.Contains(out.String(), "password") || strings.Contains(out.String(), "hunter2") {
+		t.Fatalf("form values should not be logged: %s", out.String())
+	}
+
+	// Each redirect hop is a request and response of its own
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+	b.Load(redirect.URL)
+	if hook.requests != 4 || hook.responses != 4 {
+		t.Fatalf("expected hooks to see both hops, got %+v", hook)
+	}
+
+	b.SetProxySelector(&StaticProxy{})
+	func() {
+		defer func() { recover() }()
+		b.Load("http://127.0.0.1:1/")
+	}()
+	if hook.errors != 1 {
+		t.Fatalf("expected one error, got %d", hook.errors)
+	}
+}
+
+func TestSitemaps(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow: /private\nSitemap: %s/index.xml # main\n", server.URL)
+		case "/index.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>%[1]s/pages.xml.gz</loc></sitemap>
+<sitemap><loc>%[1]s/more.txt</loc></sitemap>
+<sitemap><loc>%[1]s/missing.xml</loc></sitemap>
+<sitemap><loc>%[1]s/index.xml</loc></sitemap>
+<sitemap><loc>https://elsewhere.example/sitemap.xml</loc></sitemap>
+</sitemapindex>`, server.URL)
+		case "/pages.xml.gz":
+			zipped := gzip.NewWriter(w)
+			fmt.Fprint(zipped, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc> https://example.com/a?x=1&amp;y=2 </loc><lastmod>2024-05-01</lastmod><changefreq>Daily</changefreq><priority>0.8</priority></url>
+<url><loc>https://example.com/b</loc><lastmod>2024-05-02T10:00:00+01:00</lastmod></url>
+</urlset>`)
+			zipped.Close()
+		case "/more.txt":
+			fmt.Fprint(w, "https://example.com/c\n\nhttps://example.com/d\n")
+		case "/latin.xml":
+			fmt.Fprint(w, "<?xml version=\"1.0\" encoding=\"windows-1252\"?>"+
+				"<urlset><url><loc>https://example.com/\x93caf\xe9\x94\x96\x80</loc></url></urlset>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	entries := []SitemapEntry{}
+	errs := []error{}
+	for entry, err := range b.DiscoverSitemaps(server.URL) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %+v", entries)
+	}
+	first := entries[0]
+	if first.Loc != "https://example.com/a?x=1&y=2" || first.ChangeFreq != "daily" || first.Priority != 0.8 {
+		t.Errorf("unexpected entry %+v", first)
+	}
+	if first.LastMod.Day() != 1 || entries[1].LastMod.Hour() != 10 || entries[1].Priority != 0.5 {
+		t.Errorf("unexpected dates or default priority %+v %+v", first, entries[1])
+	}
+	if entries[3].Loc != "https://example.com/d" || entries[3].Sitemap != server.URL+"/more.txt" {
+		t.Errorf("unexpected text sitemap entry %+v", entries[3])
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "elsewhere.example") || !strings.Contains(errs[1].Error(), "missing.xml") {
+		t.Errorf("expected errors for the other host and the missing sitemap, got %v", errs)
+	}
+
+	// A robots.txt which can't be fetched isn't mistaken for a site without sitemaps
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	errs = nil
+	for _, err := range b.DiscoverSitemaps(closed.URL) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "robots.txt") {
+		t.Errorf("expected the robots.txt error, got %v", errs)
+	}
+
+	// Stopping early stops reading
+	count := 0
+	for range b.Sitemap(server.URL + "/index.xml") {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("expected iteration to stop after 1, got %d", count)
+	}
+	// Windows-1252 has quotes, dashes and the euro sign where latin-1 has control codes
+	latin := []string{}
+	for entry, err := range b.Sitemap(server.URL + "/latin.xml") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		latin = append(latin, entry.Loc)
+	}
+	if len(latin) != 1 || latin[0] != "https://example.com/\u201ccaf\u00e9\u201d\u2013\u20ac" {
+		t.Errorf("unexpected windows-1252 entries %q", latin)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		case "/items":
+			if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"error":"no session"}`)
+				return
+			}
+			if r.Header.Get("Accept") != "application/json" {
+				t.Errorf("expected a json Accept header, got %q", r.Header.Get("Accept"))
+			}
+			if r.Method == "POST" {
+				body, _ := io.ReadAll(r.Body)
+				if r.Header.Get("Content-Type") != "application/json" || string(body) != `{"name":"tea"}` {
+					t.Errorf("unexpected post %q %s", r.Header.Get("Content-Type"), body)
+				}
+				w.WriteHeader(http.StatusCreated)
+			}
+			fmt.Fprint(w, `{"items":[{"name":"tea"}]}`)
+		case "/logo.png":
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		}
+	}))
+	defer server.Close()
+
+	type items struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+
+	b := NewBrowser()
+	var got items
+	err := b.GetJSON(server.URL+"/items", &got)
+	if !errors.Is(err, ErrStatus) {
+		t.Fatalf("expected ErrStatus without a session, got %v", err)
+	}
+	var status *StatusError
+	if !errors.As(err, &status) || status.Response.StatusCode != 403 || status.Response.Text() != `{"error":"no session"}` {
+		t.Errorf("expected the error response to be readable, got %v", err)
+	}
+
+	b.Load(server.URL + "/login")
+	if err := b.GetJSON(server.URL+"/items", &got); err != nil || len(got.Items) != 1 || got.Items[0].Name != "tea" {
+		t.Fatalf("unexpected json %v %v", got, err)
+	}
+	if err := b.PostJSON(server.URL+"/items", map[string]string{"name": "tea"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.Get(server.URL+"/logo.png", nil)
+	if err != nil || !resp.Ok() || !bytes.Equal(resp.Body, []byte{0x89, 'P', 'N', 'G'}) {
+		t.Fatalf("unexpected raw response %v %v", resp, err)
+	}
+	if resp.Url != server.URL+"/logo.png" {
+		t.Errorf("unexpected url %s", resp.Url)
+	}
+	if b.getUrl() != server.URL+"/login" {
+		t.Errorf("expected the browser to stay on its page, got %s", b.getUrl())
+	}
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("grawl download "), 1000)
+	full := sha256.Sum256(content)
+	checksum := hex.EncodeToString(full[:])
+	ranged, cut := "", false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranged = r.Header.Get("Range")
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if cut {
+			// Promise the whole file then stop part way
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:4000])
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	var out bytes.Buffer
+	var done, total int64
+	result, err := b.Download(server.URL+"/file.bin", &out, &DownloadOptions{
+		Sha256:   checksum,
+		Progress: func(d, t int64) { done, total = d, t },
+	})
+	if err != nil || !bytes.Equal(out.Bytes(), content) || result.Size != int64(len(content)) || result.Validator != `"v1"` {
+		t.Fatalf("unexpected download %v %v", result, err)
+	}
+	if done != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("unexpected progress %d of %d", done, total)
+	}
+
+	// A partial file with nothing to say which version it is gets fetched again
+	path := t.TempDir() + "/file.bin"
+	if err := os.WriteFile(path, content[:4000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = b.Download(server.URL+"/file.bin", path, &DownloadOptions{Resume: true, Sha256: checksum})
+	if err != nil || result.Resumed || ranged != "" {
+		t.Fatalf("expected an unknown partial file to be replaced, got %v %v range %q", result, err, ranged)
+	}
+	if _, err := os.Stat(path + validatorSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no validator left beside a finished file, got %v", err)
+	}
+
+	// An error response leaves a good file alone
+	if _, err := b.Download(server.URL+"/gone", path, nil); !errors.Is(err, ErrStatus) {
+		t.Fatalf("expected ErrStatus, got %v", err)
+	}
+	if written, _ := os.ReadFile(path); !bytes.Equal(written, content) {
+		t.Fatal("a failed download replaced the existing file")
+	}
+
+	cut = true
+	if _, err := b.Download(server.URL+"/file.bin", path, nil); err == nil {
+		t.Fatal("expected a download cut short to fail")
+	}
+	cut = false
+	result, err = b.Download(server.URL+"/file.bin", path, &DownloadOptions{Resume: true, Sha256: checksum})
+	if err != nil || !result.Resumed || ranged != "bytes=4000-" {
+		t.Fatalf("expected a resumed download, got %v %v range %q", result, err, ranged)
+	}
+	if written, _ := os.ReadFile(path); !bytes.Equal(written, content) {
+		t.Fatal("resumed file doesn't match")
+	}
+
+	// Already complete, the server has nothing left to send
+	os.WriteFile(path+validatorSuffix, []byte(`"v1"`), 0644)
+	result, err = b.Download(server.URL+"/file.bin", path, &DownloadOptions{Resume: true})
+	if err != nil || !result.Resumed || result.Sha256 != checksum {
+		t.Fatalf("expected the complete file to be accepted, got %v %v", result, err)
+	}
+
+	// The file changed since the partial copy, If-Range gets the whole new one
+	os.WriteFile(path, []byte("stale"), 0644)
+	os.WriteFile(path+validatorSuffix, []byte(`"v0"`), 0644)
+	result, err = b.Download(server.URL+"/file.bin", path, &DownloadOptions{Resume: true, Sha256: checksum})
+	if err != nil || result.Resumed || ranged != "bytes=5-" {
+		t.Fatalf("expected a changed file to be fetched whole, got %v %v range %q", result, err, ranged)
+	}
+	if written, _ := os.ReadFile(path); !bytes.Equal(written, content) {
+		t.Fatal("refetched file doesn't match")
+	}
+
+	if _, err := b.Download(server.URL+"/file.bin", io.Discard, &DownloadOptions{Sha256: "00"}); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+	if _, err := b.Download(server.URL+"/file.bin", 42, nil); err == nil {
+		t.Errorf("expected an error for an unusable destination")
+	}
+}
+
+func TestRequestBuilder(t *testing.T) {
+	var last *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			r.ParseMultipartForm(1 << 20)
+			file, header, _ := r.FormFile("upload")
+			data, _ := io.ReadAll(file)
+			body = r.FormValue("title") + " " + header.Filename + " " + string(data)
+		} else {
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+		}
+		fmt.Fprint(w, `<html><body><p id="done">ok</p></body></html>`)
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	b.SetHeader("X-Default", "yes")
+
+	page, err := b.Request("put", server.URL+"/profile?a=1").
+		Query("b", "2").
+		Header("X-Token", "t").
+		Form(url.Values{"name": {"grawl"}}).
+		Page()
+	if err != nil || page.ById("done") == nil {
+		t.Fatalf("unexpected page %v %v", page, err)
+	}
+	if last.Method != "PUT" || last.URL.RawQuery != "a=1&b=2" || body != "name=grawl" {
+		t.Fatalf("unexpected request %s %s %q", last.Method, last.URL.RawQuery, body)
+	}
+	if last.Header.Get("X-Token") != "t" || last.Header.Get("X-Default") != "yes" {
+		t.Errorf("expected builder and default headers, got %v", last.Header)
+	}
+	if b.getUrl() != server.URL+"/profile?a=1&b=2" {
+		t.Errorf("expected the browser to move to the page, got %s", b.getUrl())
+	}
+
+	resp, err := b.Request("PATCH", server.URL+"/item").JSON(map[string]int{"qty": 2}).Response()
+	if err != nil || !resp.Ok() || body != `{"qty":2}` || last.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected json request %q %v", body, err)
+	}
+
+	upload := b.Request("POST", server.URL+"/upload").
+		Field("title", "notes").
+		File("upload", "notes.txt", strings.NewReader("hello"))
+	for i := 0; i < 2; i++ {
+		if _, err := upload.Response(); err != nil || body != "notes notes.txt hello" {
+			t.Fatalf("unexpected multipart body on send %d %q %v", i+1, body, err)
+		}
+	}
+
+	// Parameters are added after the query as written, not re-encoded and reordered
+	if _, err := b.Request("GET", server.URL+"/search?z=1&a=%7e&flag").Query("q", "a b").Response(); err != nil {
+		t.Fatal(err)
+	}
+	if last.URL.RawQuery != "z=1&a=%7e&flag&q=a+b" {
+		t.Errorf("unexpected query %q", last.URL.RawQuery)
+	}
+
+	sent, err := b.Request("DELETE", server.URL+"/item").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent.Body.Close()
+	if last.Method != "DELETE" || body != "" {
+		t.Errorf("expected an empty delete, got %s %q", last.Method, body)
+	}
+
+	// A meta refresh which can't be followed is an error, not a panic
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	refresh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><meta http-equiv="refresh" content="0;url=%s/"></head></html>`, closed.URL)
+	}))
+	defer refresh.Close()
+	b.GetRedirectPolicy().FollowMetaRefresh = true
+	if page, err := b.Request("GET", refresh.URL).Page(); err == nil {
+		t.Errorf("expected an error following the refresh, got %v", page)
+	}
+}
+
+func TestJsRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/interstitial":
+			fmt.Fprint(w, `<html><body><script>window.location.href = "/landing";</script></body></html>`)
+		case "/loop":
+			fmt.Fprint(w, `<html><body><script>location.replace("/loop?again=1")</script></body></html>`)
+		case "/script":
+			fmt.Fprint(w, `<html><body><p id="x">stay</p><script>location = "javascript:alert(1)"</script></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><p id="x">landed</p></html>`)
+		}
+	}))
+	defer server.Close()
+
+	b := NewBrowser()
+	page := b.Load(server.URL + "/interstitial")
+	if page.ById("x") != nil {
+		t.Fatal("script redirects should not be followed by default")
+	}
+
+	b.GetRedirectPolicy().FollowJsRedirect = true
+	page = b.Load(server.URL + "/interstitial")
+	if page.ById("x") == nil || page.GetUrl() != server.URL+"/landing" {
+		t.Fatalf("expected to land on /landing, got %s", page.GetUrl())
+	}
+	chain := page.GetRedirects()
+	want := element.Redirect{From: server.URL + "/interstitial", To: server.URL + "/landing", StatusCode: http.StatusOK, Kind: element.REDIRECT_JAVASCRIPT}
+	if len(chain) != 1 || chain[0] != want {
+		t.Fatalf("unexpected chain %v", chain)
+	}
+
+	page = b.Load(server.URL + "/script")
+	if page.ById("x") == nil || page.GetUrl() != server.URL+"/script" || len(page.GetRedirects()) != 0 {
+		t.Fatalf("expected a javascript: target to be refused, got %s %v", page.GetUrl(), page.GetRedirects())
+	}
+
+	// A script redirect which can't be followed comes back as an error
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><script>location.replace("%s/")</script></body></html>`, closed.URL)
+	}))
+	defer broken.Close()
+	if _, err := b.Request("GET", broken.URL).Page(); err == nil || !strings.Contains(err.Error(), "script redirect") {
+		t.Errorf("expected an error following the script redirect, got %v", err)
+	}
+
+	b.GetRedirectPolicy().MaxHops = 3
+	page = b.Load(server.URL + "/loop")
+	if len(page.GetRedirects()) != 1 || page.GetUrl() != server.URL+"/loop?again=1" {
+		t.Fatalf("expected one hop before the page redirected to itself, got %v", page.GetRedirects())
+	}
+}
@@ -0,0 +1,285 @@
+package browser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrIncomplete = errors.New("grawl: download incomplete")
+var ErrChecksum = errors.New("grawl: download checksum mismatch")
+
+// Kept next to a partial file, the validator of the response it came from
+const validatorSuffix = ".grawl-validator"
+
+// Settings for a single Browser.Download, the zero value just downloads
+type DownloadOptions struct {
+	// Extra headers for this request only
+	Headers http.Header
+	// Called as the body arrives, total is -1 if the server didn't give a length
+	Progress func(done, total int64)
+	/*
+		Carry on from the end of a partial file rather than starting again.
+		Only a file from a response with an ETag or Last-Modified can be
+		resumed, it is sent as If-Range so a changed file is fetched whole.
+	*/
+	Resume bool
+	// Expected sha-256 of the whole file in hex, checked once it is written
+	Sha256 string
+}
+
+// What a finished download fetched
+type Download struct {
+	// The url the file came from, after any redirects
+	Url string
+	// Bytes in the whole file, including any resumed part
+	Size int64
+	// Hex sha-256 of the whole file
+	Sha256 string
+	// Whether an existing partial file was carried on from
+	Resumed bool
+	// The strong ETag or Last-Modified of the response, empty if it had neither
+	Validator string
+}
+
+/*
+	Download a url into dest, which is either a file path or an io.Writer,
+	with the browsers cookies, headers and proxies. The length the server
+	gave is checked and ErrIncomplete returned if the body came up short.
+	A partial file is left in place, with its validator beside it in
+	dest+".grawl-validator", so it can be resumed later. Resume only
+	applies when dest is a path.
+	Example:
+	_, err := b.Download(pdfUrl, "report.pdf", &browser.DownloadOptions{
+		Resume: true,
+		Progress: func(done, total int64) { log.Println(done, "of", total) },
+	})
+*/
+func (b *Browser) Download(url string, dest interface{}, opts *DownloadOptions) (*Download, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+
+	switch d := dest.(type) {
+	case string:
+		return b.downloadFile(url, d, opts)
+	case io.Writer:
+		return b.download(url, d, 0, "", sha256.New(), opts)
+	default:
+		return nil, fmt.Errorf("grawl: can't download to a %T", dest)
+	}
+}
+
+func (b *Browser) downloadFile(url, path string, opts *DownloadOptions) (*Download, error) {
+	var offset int64
+	var validator string
+	sum := sha256.New()
+
+	if opts.Resume {
+		// Without a validator a partial file might be from another version, so start again
+		if saved, err := os.ReadFile(path + validatorSuffix); err == nil {
+			validator = strings.TrimSpace(string(saved))
+		}
+		if existing, err := os.Open(path); err == nil && validator != "" {
+			// The checksum covers the whole file so hash the part already there
+			offset, err = io.Copy(sum, existing)
+			existing.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The file is only opened once the response is good, so an error page never replaces it
+	writer := &fileWriter{path: path, offset: offset}
+	defer writer.close()
+
+	result, err := b.download(url, writer, offset, validator, sum, opts)
+	if err != nil {
+		return result, err
+	}
+	os.Remove(path + validatorSuffix)
+	if writer.file == nil {
+		// Already complete, nothing was written
+		return result, nil
+	}
+	return result, writer.file.Truncate(result.Size)
+}
+
+/*
+	Writes to a file from an offset, which is moved back to the start
+	if the server ignores the range and sends the whole file. The file
+	isn't touched until open is called.
+*/
+type fileWriter struct {
+	file   *os.File
+	path   string
+	offset int64
+}
+
+func (f *fileWriter) Write(p []byte) (int, error) {
+	n, err := f.file.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+/*
+	Open the file to write the body into, a download from the start
+	replaces whatever was there. The validator is saved before the body
+	arrives so an interrupted download can be resumed.
+*/
+func (f *fileWriter) open(validator string) error {
+	flags := os.O_CREATE | os.O_WRONLY
+	if f.offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(f.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return f.remember(validator)
+}
+
+func (f *fileWriter) close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+func (f *fileWriter) remember(validator string) error {
+	if validator == "" {
+		err := os.Remove(f.path + validatorSuffix)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(f.path+validatorSuffix, []byte(validator), 0644)
+}
+
+func (b *Browser) download(url string, w io.Writer, offset int64, validator string, sum hash.Hash, opts *DownloadOptions) (*Download, error) {
+	headers := opts.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if offset > 0 {
+		headers.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		headers.Set("If-Range", validator)
+		// A compressed body can't be resumed by byte offset
+		headers.Set("Accept-Encoding", "identity")
+	}
+
+	resp, err := b.Fetch(url, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := Download{Url: resp.Request.URL.String(), Validator: responseValidator(resp)}
+	total := int64(-1)
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		start, size := parseContentRange(resp.Header.Get("Content-Range"))
+		if start != offset {
+			return nil, fmt.Errorf("grawl: asked to resume at %d but got a range from %d", offset, start)
+		}
+		result.Resumed = true
+		total = size
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to fetch if the file is already complete
+		if _, size := parseContentRange(resp.Header.Get("Content-Range")); size == offset {
+			result.Size = offset
+			result.Resumed = true
+			return finishDownload(&result, sum, opts)
+		}
+		return nil, fmt.Errorf("%w: %s from %s", ErrStatus, resp.Status, result.Url)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// The server sent the whole file, start again
+		if offset > 0 {
+			if f, ok := w.(*fileWriter); ok {
+				f.offset = 0
+			}
+			sum.Reset()
+			offset = 0
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s from %s", ErrStatus, resp.Status, result.Url)
+	}
+
+	if f, ok := w.(*fileWriter); ok {
+		if err := f.open(result.Validator); err != nil {
+			return nil, err
+		}
+	}
+
+	if total < 0 && resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	progress := &progressWriter{done: offset, total: total, report: opts.Progress}
+	written, err := io.Copy(io.MultiWriter(w, sum, progress), resp.Body)
+	result.Size = offset + written
+	if err != nil {
+		return &result, err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return &result, fmt.Errorf("%w: got %d of %d bytes", ErrIncomplete, written, resp.ContentLength)
+	}
+	return finishDownload(&result, sum, opts)
+}
+
+// A strong ETag or else Last-Modified, weak ETags can't be used with If-Range
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+func finishDownload(result *Download, sum hash.Hash, opts *DownloadOptions) (*Download, error) {
+	result.Sha256 = hex.EncodeToString(sum.Sum(nil))
+	if opts.Sha256 != "" && !strings.EqualFold(opts.Sha256, result.Sha256) {
+		return result, fmt.Errorf("%w: expected %s, got %s", ErrChecksum, opts.Sha256, result.Sha256)
+	}
+	return result, nil
+}
+
+type progressWriter struct {
+	done, total int64
+	report      func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.report != nil {
+		p.report(p.done, p.total)
+	}
+	return len(b), nil
+}
+
+// Read the start and full size from a Content-Range such as "bytes 100-199/200", -1 if unknown
+func parseContentRange(header string) (start, size int64) {
+	start, size = -1, -1
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !found {
+		return
+	}
+	span, total, _ := strings.Cut(spec, "/")
+	if n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64); err == nil {
+		size = n
+	}
+	first, _, _ := strings.Cut(span, "-")
+	if n, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64); err == nil {
+		start = n
+	}
+	return
+}
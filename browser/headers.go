@@ -0,0 +1,271 @@
+package browser
+
+import (
+	"code.google.com/p/go.net/publicsuffix"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+	A named set of headers matching what a real browser sends.
+	Only the names and values match, go's transport writes headers
+	sorted by name rather than in the order a browser would.
+	Accept-Encoding is left out on purpose so go's transport
+	can still transparently decompress responses.
+	Sec-Fetch-Site is worked out for each request from its referer.
+*/
+type HeaderProfile struct {
+	Name      string
+	UserAgent string
+	Headers   [][2]string
+}
+
+var (
+	ProfileChromeDesktop = &HeaderProfile{
+		Name:      "chrome",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		Headers: [][2]string{
+			{"Sec-Ch-Ua", `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`},
+			{"Sec-Ch-Ua-Mobile", "?0"},
+			{"Sec-Ch-Ua-Platform", `"Windows"`},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-User", "?1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	}
+
+	ProfileFirefoxDesktop = &HeaderProfile{
+		Name:      "firefox",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		Headers: [][2]string{
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Accept-Language", "en-US,en;q=0.5"},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-User", "?1"},
+		},
+	}
+
+	ProfileSafariDesktop = &HeaderProfile{
+		Name:      "safari",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+		Headers: [][2]string{
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	}
+
+	ProfileChromeMobile = &HeaderProfile{
+		Name:      "chrome-mobile",
+		UserAgent: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+		Headers: [][2]string{
+			{"Sec-Ch-Ua", `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`},
+			{"Sec-Ch-Ua-Mobile", "?1"},
+			{"Sec-Ch-Ua-Platform", `"Android"`},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-User", "?1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	}
+
+	ProfileSafariMobile = &HeaderProfile{
+		Name:      "safari-mobile",
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+		Headers: [][2]string{
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	}
+)
+
+var headerProfiles = map[string]*HeaderProfile{}
+
+func init() {
+	for _, p := range []*HeaderProfile{
+		ProfileChromeDesktop,
+		ProfileFirefoxDesktop,
+		ProfileSafariDesktop,
+		ProfileChromeMobile,
+		ProfileSafariMobile,
+	} {
+		headerProfiles[p.Name] = p
+	}
+}
+
+/*
+	Return a built in profile by name or nil if there is no such profile
+	Example: GetHeaderProfile("firefox")
+*/
+func GetHeaderProfile(name string) *HeaderProfile {
+	return headerProfiles[strings.ToLower(name)]
+}
+
+/*
+	Set a header sent with every request, User-Agent is managed through
+	SetUserAgent instead.
+	Example: b.SetHeader("Accept-Language", "de-DE,de;q=0.9")
+*/
+func (b *Browser) SetHeader(key, value string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.headers.Set(key, value)
+}
+
+// Stop sending a default header
+func (b *Browser) RemoveHeader(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.headers.Del(key)
+}
+
+// Return a copy of the headers sent with every request
+func (b *Browser) GetHeaders() http.Header {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.headers.Clone()
+}
+
+/*
+	Replace the user agent and default headers with those of a profile,
+	nil goes back to the ones a NewBrowser starts with
+	Example: b.SetHeaderProfile(browser.ProfileFirefoxDesktop)
+*/
+func (b *Browser) SetHeaderProfile(profile *HeaderProfile) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if profile == nil {
+		b.userAgent = UAgent
+		b.headers = defaultHeaders()
+		return
+	}
+	b.userAgent = profile.UserAgent
+	b.headers = http.Header{}
+	for _, h := range profile.Headers {
+		b.headers.Add(h[0], h[1])
+	}
+}
+
+// The headers a NewBrowser sends
+func defaultHeaders() http.Header {
+	headers := http.Header{}
+	headers.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	headers.Set("Accept-Language", "en-US,en;q=0.5")
+	return headers
+}
+
+/*
+	Turn automatic Referer and Origin headers on or off.
+	When on, requests carry the current page as their Referer and
+	form submissions carry its Origin. Defaults to on.
+*/
+func (b *Browser) SetAutoReferer(on bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.autoReferer = on
+}
+
+/*
+	Build a request carrying the user agent, default headers and
+	referer, any headers given here override the defaults
+*/
+func (b *Browser) newRequest(method, target string, body io.Reader, headers http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	current, autoReferer, userAgent := b.url, b.autoReferer, b.userAgent
+	for key, vals := range b.headers {
+		req.Header[key] = append([]string(nil), vals...)
+	}
+	b.mutex.RUnlock()
+
+	// Pages loaded from files never leak their path as a referer
+	var from *url.URL
+	if u, err := url.Parse(current); err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		from = u
+	}
+	if from != nil && autoReferer {
+		// Neither is sent from https down to http
+		if referer := refererFor(from, req.URL); referer != "" {
+			req.Header.Set("Referer", referer)
+			if method != "GET" && method != "HEAD" {
+				req.Header.Set("Origin", from.Scheme+"://"+from.Host)
+			}
+		}
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	if req.Header.Get("Sec-Fetch-Site") != "" {
+		req.Header.Set("Sec-Fetch-Site", fetchSite(from, req.URL))
+	}
+
+	for key, vals := range headers {
+		req.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), vals...)
+	}
+
+	return req, nil
+}
+
+// The Sec-Fetch-Site a browser would send going from one page to another
+func fetchSite(from, to *url.URL) string {
+	switch {
+	case from == nil:
+		return "none"
+	case from.Scheme == to.Scheme && from.Host == to.Host:
+		return "same-origin"
+	}
+
+	fromSite, err := publicsuffix.EffectiveTLDPlusOne(from.Hostname())
+	if err != nil {
+		return "cross-site"
+	}
+	toSite, err := publicsuffix.EffectiveTLDPlusOne(to.Hostname())
+	if err != nil || fromSite != toSite || from.Scheme != to.Scheme {
+		return "cross-site"
+	}
+	return "same-site"
+}
+
+/*
+	The Referer for a request from one page to another, following the
+	strict-origin-when-cross-origin policy browsers default to: the full
+	url within a site, only the origin to other sites and nothing at all
+	from https down to http, so paths and query strings don't leak.
+*/
+func refererFor(from, to *url.URL) string {
+	if from.Scheme == "https" && to.Scheme != "https" {
+		return ""
+	}
+	if from.Scheme != to.Scheme || from.Host != to.Host {
+		return from.Scheme + "://" + from.Host + "/"
+	}
+	u := *from
+	u.User = nil
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,176 @@
+package browser
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+/*
+	Hooks let middleware watch every request a browser sends, for metrics,
+	tracing or redaction. Hooks are registered with Browser.OnRequest,
+	OnResponse and OnError and see each redirect hop as its own request.
+*/
+
+// Called before each request is sent, headers may still be changed here
+type RequestHook interface {
+	OnRequest(req *http.Request)
+}
+
+// Called once a response has arrived, before its body is read
+type ResponseHook interface {
+	OnResponse(resp *http.Response, elapsed time.Duration)
+}
+
+// Called when a request fails to get a response
+type ErrorHook interface {
+	OnError(req *http.Request, err error)
+}
+
+// Adapters so plain functions can be used as hooks
+type RequestHookFunc func(req *http.Request)
+type ResponseHookFunc func(resp *http.Response, elapsed time.Duration)
+type ErrorHookFunc func(req *http.Request, err error)
+
+func (f RequestHookFunc) OnRequest(req *http.Request) {
+	f(req)
+}
+
+func (f ResponseHookFunc) OnResponse(resp *http.Response, elapsed time.Duration) {
+	f(resp, elapsed)
+}
+
+func (f ErrorHookFunc) OnError(req *http.Request, err error) {
+	f(req, err)
+}
+
+/*
+	Register a hook called before every request, including each redirect hop
+	Example: b.OnRequest(browser.RequestHookFunc(func(req *http.Request) {
+		req.Header.Set("X-Trace", traceId)
+	}))
+*/
+func (b *Browser) OnRequest(hook RequestHook) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.requestHooks = append(b.requestHooks, hook)
+}
+
+/*
+	Register a hook called for every response, including redirect responses
+	Example: count responses by status code
+	b.OnResponse(browser.ResponseHookFunc(func(resp *http.Response, elapsed time.Duration) {
+		statusCounts[resp.StatusCode]++
+	}))
+*/
+func (b *Browser) OnResponse(hook ResponseHook) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.responseHooks = append(b.responseHooks, hook)
+}
+
+// Register a hook called when a request fails to get a response
+func (b *Browser) OnError(hook ErrorHook) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.errorHooks = append(b.errorHooks, hook)
+}
+
+// Remove all registered hooks
+func (b *Browser) ClearHooks() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.requestHooks = nil
+	b.responseHooks = nil
+	b.errorHooks = nil
+}
+
+/*
+	Set the logger the browser reports its activity to, nil silences it.
+	Form values and query strings are never logged.
+	Example: b.SetLogger(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+*/
+func (b *Browser) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger()
+	}
+	b.logger = logger
+}
+
+func (b *Browser) GetLogger() *slog.Logger {
+	return b.logger
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Hooks are copied out so one registered mid request doesn't race
+func (b *Browser) onRequest(req *http.Request) {
+	b.logger.Debug("sending request", "method", req.Method, "url", redactUrl(req.URL))
+	b.mutex.RLock()
+	hooks := b.requestHooks
+	b.mutex.RUnlock()
+	for _, h := range hooks {
+		h.OnRequest(req)
+	}
+}
+
+func (b *Browser) onResponse(resp *http.Response, elapsed time.Duration) {
+	b.logger.Debug("received response", "method", resp.Request.Method, "url", redactUrl(resp.Request.URL),
+		"status", resp.StatusCode, "elapsed", elapsed)
+	b.mutex.RLock()
+	hooks := b.responseHooks
+	b.mutex.RUnlock()
+	for _, h := range hooks {
+		h.OnResponse(resp, elapsed)
+	}
+}
+
+func (b *Browser) onError(req *http.Request, err error) {
+	b.logger.Warn("request failed", "method", req.Method, "url", redactUrl(req.URL), "error", err)
+	b.mutex.RLock()
+	hooks := b.errorHooks
+	b.mutex.RUnlock()
+	for _, h := range hooks {
+		h.OnError(req, err)
+	}
+}
+
+/*
+	Times each hop of a request, the client follows redirects itself
+	so the clock is restarted as each redirect response is reported
+*/
+type hopClock struct {
+	start time.Time
+}
+
+type clockKey struct{}
+
+func withClock(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clockKey{}, &hopClock{time.Now()}))
+}
+
+// Time since the current hop was sent, starting the clock for the next one
+func lapHop(ctx context.Context) time.Duration {
+	clock, ok := ctx.Value(clockKey{}).(*hopClock)
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	elapsed := now.Sub(clock.start)
+	clock.start = now
+	return elapsed
+}
+
+// Strip credentials and query values which may hold form data from a url
+func redactUrl(u *url.URL) string {
+	r := *u
+	r.User = nil
+	r.RawQuery = ""
+	r.Fragment = ""
+	return r.String()
+}
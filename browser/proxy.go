@@ -0,0 +1,198 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrNoProxy = errors.New("grawl: no healthy proxy available")
+
+/*
+	A ProxySelector chooses the proxy used for each request a Browser sends.
+	Returning a nil url sends the request directly.
+	Report is called once the request completes so selectors can track the
+	health of their proxies, err is nil when the proxy worked.
+*/
+type ProxySelector interface {
+	Proxy(req *http.Request) (*url.URL, error)
+	Report(proxy *url.URL, err error)
+}
+
+// A ProxySelector which sends every request through the same proxy
+type StaticProxy struct {
+	url *url.URL
+}
+
+/*
+	Construct a StaticProxy, both http and socks5 proxies are supported
+	Example: NewStaticProxy("socks5://127.0.0.1:1080")
+*/
+func NewStaticProxy(proxyUrl string) (*StaticProxy, error) {
+	u, err := parseProxyUrl(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticProxy{u}, nil
+}
+
+func (s *StaticProxy) Proxy(req *http.Request) (*url.URL, error) {
+	return s.url, nil
+}
+
+func (s *StaticProxy) Report(proxy *url.URL, err error) {
+}
+
+/*
+	A ProxySelector which reads the proxy from the HTTP_PROXY, HTTPS_PROXY
+	and NO_PROXY environment variables
+*/
+type EnvProxy struct{}
+
+func (e EnvProxy) Proxy(req *http.Request) (*url.URL, error) {
+	return http.ProxyFromEnvironment(req)
+}
+
+func (e EnvProxy) Report(proxy *url.URL, err error) {
+}
+
+/*
+	A ProxySelector which rotates through a pool of proxies on each request.
+	A proxy which fails MaxFailures times in a row is taken out of rotation
+	for Cooldown, a Cooldown of 0 removes it for good.
+*/
+type ProxyPool struct {
+	MaxFailures int
+	Cooldown    time.Duration
+	mutex       sync.Mutex
+	proxies     []*poolProxy
+	next        int
+}
+
+type poolProxy struct {
+	url      *url.URL
+	failures int
+	retryAt  time.Time
+}
+
+/*
+	Construct a ProxyPool from a list of proxy urls
+	Example: NewProxyPool("http://10.0.0.1:3128", "socks5://10.0.0.2:1080")
+*/
+func NewProxyPool(proxyUrls ...string) (*ProxyPool, error) {
+	p := ProxyPool{}
+	p.MaxFailures = 3
+	p.Cooldown = 5 * time.Minute
+
+	for _, proxyUrl := range proxyUrls {
+		u, err := parseProxyUrl(proxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, &poolProxy{url: u})
+	}
+
+	return &p, nil
+}
+
+// Return the next healthy proxy in the pool
+func (p *ProxyPool) Proxy(req *http.Request) (*url.URL, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(p.proxies); i++ {
+		proxy := p.proxies[p.next]
+		p.next = (p.next + 1) % len(p.proxies)
+
+		if p.healthy(proxy, now) {
+			if proxy.failures >= p.MaxFailures {
+				// Cooldown is over, give it one more chance before it is taken out again
+				proxy.failures = p.MaxFailures - 1
+			}
+			return proxy.url, nil
+		}
+	}
+
+	return nil, ErrNoProxy
+}
+
+// Record the outcome of a request sent through one of the pools proxies
+func (p *ProxyPool) Report(proxy *url.URL, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, pp := range p.proxies {
+		if pp.url.String() != proxy.String() {
+			continue
+		}
+
+		if err == nil {
+			pp.failures = 0
+		} else {
+			pp.failures++
+			if pp.failures >= p.MaxFailures {
+				pp.retryAt = time.Now().Add(p.Cooldown)
+			}
+		}
+	}
+}
+
+// Return the proxies currently in rotation
+func (p *ProxyPool) Healthy() []*url.URL {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	healthy := []*url.URL{}
+	now := time.Now()
+	for _, proxy := range p.proxies {
+		if p.healthy(proxy, now) {
+			healthy = append(healthy, proxy.url)
+		}
+	}
+	return healthy
+}
+
+func (p *ProxyPool) healthy(proxy *poolProxy, now time.Time) bool {
+	if proxy.failures < p.MaxFailures {
+		return true
+	}
+
+	// A proxy with no cooldown never comes back
+	if p.Cooldown <= 0 {
+		return false
+	}
+
+	return now.After(proxy.retryAt)
+}
+
+func parseProxyUrl(proxyUrl string) (*url.URL, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("grawl: unsupported proxy scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+type proxyKey struct{}
+
+// Tag a request with the proxy chosen for it by the browsers ProxySelector
+func withProxy(req *http.Request, proxy *url.URL) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy))
+}
+
+// Transport proxy func which reads back the proxy chosen in Browser.do
+func proxyFromRequest(req *http.Request) (*url.URL, error) {
+	proxy, _ := req.Context().Value(proxyKey{}).(*url.URL)
+	return proxy, nil
+}
@@ -0,0 +1,228 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tlowry/grawl/element"
+	"net/http"
+	"net/url"
+)
+
+var ErrRedirectBlocked = errors.New("grawl: redirect blocked by policy")
+
+/*
+	Controls which redirects a browser follows.
+	A hop the policy refuses stops navigation with ErrRedirectBlocked,
+	or hands back the redirect response itself if UseLastResponse is set.
+*/
+type RedirectPolicy struct {
+	// Most hops to follow, 0 follows none
+	MaxHops int
+	// Only follow redirects which stay on the same host
+	SameHostOnly bool
+	// Refuse redirects from https to http
+	BlockDowngrade bool
+	// Follow <meta http-equiv="refresh"> redirects too
+	FollowMetaRefresh bool
+	// Longest meta refresh delay in seconds to follow, slower ones are left for a person to read
+	MaxRefreshDelay int
+	// Follow scripts which only set window.location, see Page.JsRedirect
+	FollowJsRedirect bool
+	// Return the refused redirect response as the page instead of failing
+	UseLastResponse bool
+	// Called for each hop the other rules allow, return false to refuse it
+	Approve func(hop element.Redirect) bool
+}
+
+// Construct a RedirectPolicy which behaves like go's default client
+func NewRedirectPolicy() *RedirectPolicy {
+	p := RedirectPolicy{}
+	p.MaxHops = 10
+	p.MaxRefreshDelay = 5
+	return &p
+}
+
+/*
+	Replace the browsers redirect policy, nil restores the default
+	Example: only follow redirects within the site being scraped
+	policy := browser.NewRedirectPolicy()
+	policy.SameHostOnly = true
+	b.SetRedirectPolicy(policy)
+*/
+func (b *Browser) SetRedirectPolicy(policy *RedirectPolicy) {
+	if policy == nil {
+		policy = NewRedirectPolicy()
+	}
+	b.redirectPolicy = policy
+}
+
+// Return the redirect policy in use, changes to it apply to the next request
+func (b *Browser) GetRedirectPolicy() *RedirectPolicy {
+	return b.redirectPolicy
+}
+
+// Check a hop against the policy, hops is the number already taken
+func (p *RedirectPolicy) allow(hop element.Redirect, hops int) error {
+	if hops >= p.MaxHops {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrRedirectBlocked, hops)
+	}
+
+	from, err := url.Parse(hop.From)
+	if err != nil {
+		return err
+	}
+	to, err := url.Parse(hop.To)
+	if err != nil {
+		return err
+	}
+
+	// Never hand a javascript: or file: url to the client
+	if to.Scheme != "http" && to.Scheme != "https" {
+		return fmt.Errorf("%w: %s is not http", ErrRedirectBlocked, hop.To)
+	}
+	if p.SameHostOnly && from.Host != to.Host {
+		return fmt.Errorf("%w: %s leaves %s", ErrRedirectBlocked, hop.To, from.Host)
+	}
+	if p.BlockDowngrade && from.Scheme == "https" && to.Scheme == "http" {
+		return fmt.Errorf("%w: %s downgrades to http", ErrRedirectBlocked, hop.To)
+	}
+	if p.Approve != nil && !p.Approve(hop) {
+		return fmt.Errorf("%w: %s was not approved", ErrRedirectBlocked, hop.To)
+	}
+	return nil
+}
+
+/*
+	Called by the client before following each redirect.
+	Authorization is dropped when the redirect leaves the original host
+	and recomputed for hosts the browser holds credentials for.
+*/
+func (b *Browser) checkRedirect(req *http.Request, via []*http.Request) error {
+	hop := element.Redirect{
+		From:       via[len(via)-1].URL.String(),
+		To:         req.URL.String(),
+		StatusCode: req.Response.StatusCode,
+		Kind:       element.REDIRECT_HTTP,
+	}
+
+	hops := len(via) - 1 + len(chainFrom(req.Context()))
+	if err := b.redirectPolicy.allow(hop, hops); err != nil {
+		if b.redirectPolicy.UseLastResponse {
+			return http.ErrUseLastResponse
+		}
+		return err
+	}
+
+	b.logger.Debug("following redirect", "status", hop.StatusCode, "to", redactUrl(req.URL))
+
+	// Go copies Authorization to the same host even over http, so compare origins
+	if origin(req.URL) != origin(via[0].URL) || b.auth.has(req.URL) {
+		req.Header.Del("Authorization")
+	}
+	b.auth.authorize(req)
+
+	if b.redirect != nil {
+		if err := b.redirect(req, via); err != nil {
+			return err
+		}
+	}
+
+	// Hooks see each hop, the final response is reported by send
+	b.onResponse(req.Response, lapHop(req.Context()))
+	b.onRequest(req)
+	return nil
+}
+
+/*
+	Rebuild the http redirects a response went through by walking
+	back along the requests each redirect response created
+*/
+func redirectChain(resp *http.Response) []element.Redirect {
+	hops := []element.Redirect{}
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hop := element.Redirect{
+			From:       req.Response.Request.URL.String(),
+			To:         req.URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Kind:       element.REDIRECT_HTTP,
+		}
+		hops = append([]element.Redirect{hop}, hops...)
+	}
+	return hops
+}
+
+/*
+	Parse a response into a page and make it the browsers current page,
+	following a meta refresh or script redirect on it if the redirect
+	policy asks for that. The error is from following such a redirect.
+*/
+func (b *Browser) toPage(resp *http.Response) (*element.Page, error) {
+	page := element.ParseResp(resp)
+
+	b.setUrl(resp.Request.URL.String())
+	page.SetUrl(resp.Request.URL.String())
+
+	chain := append([]element.Redirect{}, chainFrom(resp.Request.Context())...)
+	chain = append(chain, redirectChain(resp)...)
+	page.SetRedirects(chain)
+
+	target, kind := b.clientRedirect(page)
+	if target == "" || target == page.GetUrl() {
+		return page, nil
+	}
+
+	hop := element.Redirect{
+		From:       page.GetUrl(),
+		To:         target,
+		StatusCode: resp.StatusCode,
+		Kind:       kind,
+	}
+	if b.redirectPolicy.allow(hop, len(chain)) != nil {
+		return page, nil
+	}
+
+	what := "meta refresh"
+	if kind == element.REDIRECT_JAVASCRIPT {
+		what = "script redirect"
+	}
+	req, err := b.newRequest("GET", target, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("grawl: following %s to %s: %w", what, target, err)
+	}
+	req = req.WithContext(withChain(req.Context(), append(chain, hop)))
+
+	next, err := b.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("grawl: following %s to %s: %w", what, target, err)
+	}
+	return b.toPage(next)
+}
+
+// Find a meta refresh or script redirect on a page, if the policy follows that kind
+func (b *Browser) clientRedirect(page *element.Page) (string, element.RedirectKind) {
+	policy := b.redirectPolicy
+	if policy.FollowMetaRefresh {
+		if target, delay := page.MetaRefresh(); target != "" && delay <= policy.MaxRefreshDelay {
+			return target, element.REDIRECT_META_REFRESH
+		}
+	}
+	if policy.FollowJsRedirect {
+		if target := page.JsRedirect(); target != "" {
+			return target, element.REDIRECT_JAVASCRIPT
+		}
+	}
+	return "", 0
+}
+
+type chainKey struct{}
+
+// Carry the hops taken so far across a non http redirect
+func withChain(ctx context.Context, chain []element.Redirect) context.Context {
+	return context.WithValue(ctx, chainKey{}, chain)
+}
+
+func chainFrom(ctx context.Context) []element.Redirect {
+	chain, _ := ctx.Value(chainKey{}).([]element.Redirect)
+	return chain
+}
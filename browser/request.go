@@ -0,0 +1,250 @@
+package browser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/tlowry/grawl/element"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type bodyKind int
+
+const (
+	bodyNone bodyKind = iota
+	bodyRaw
+	bodyForm
+	bodyJson
+	bodyMultipart
+)
+
+/*
+	A RequestBuilder puts together a request of any method, sent through
+	the browser so cookies, headers, auth, proxies and hooks all apply.
+	Setting a body replaces any body set before it. A builder can be sent
+	more than once, except with a Body reader which is used up by the first send.
+	Example:
+	page, err := b.Request("PUT", "https://example.com/profile").
+		Query("lang", "en").
+		Header("X-Csrf-Token", token).
+		Form(url.Values{"name": {"grawl"}}).
+		Page()
+*/
+type RequestBuilder struct {
+	browser     *Browser
+	method      string
+	target      string
+	ctx         context.Context
+	query       url.Values
+	headers     http.Header
+	kind        bodyKind
+	raw         io.Reader
+	contentType string
+	form        url.Values
+	json        interface{}
+	parts       []multipartPart
+	// The first error from reading a File, returned when sending
+	err error
+}
+
+// A field or file in a multipart body, files have content
+type multipartPart struct {
+	name     string
+	filename string
+	value    string
+	content  []byte
+}
+
+// Start building a request, the method is any http method such as PATCH or OPTIONS
+func (b *Browser) Request(method, target string) *RequestBuilder {
+	r := RequestBuilder{}
+	r.browser = b
+	r.method = strings.ToUpper(method)
+	r.target = target
+	r.ctx = context.Background()
+	r.query = url.Values{}
+	r.headers = http.Header{}
+	r.form = url.Values{}
+	return &r
+}
+
+// Add a query parameter to the url, alongside any it already has
+func (r *RequestBuilder) Query(key, value string) *RequestBuilder {
+	r.query.Add(key, value)
+	return r
+}
+
+// Set a header for this request only, overriding the browsers default
+func (r *RequestBuilder) Header(key, value string) *RequestBuilder {
+	r.headers.Set(key, value)
+	return r
+}
+
+func (r *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	r.ctx = ctx
+	return r
+}
+
+// Send a body as it is, contentType may be empty
+func (r *RequestBuilder) Body(body io.Reader, contentType string) *RequestBuilder {
+	r.kind = bodyRaw
+	r.raw = body
+	r.contentType = contentType
+	return r
+}
+
+// Send url encoded form values, repeated calls add to the form
+func (r *RequestBuilder) Form(values url.Values) *RequestBuilder {
+	r.kind = bodyForm
+	for key, vals := range values {
+		r.form[key] = append(r.form[key], vals...)
+	}
+	return r
+}
+
+// Send v encoded as json
+func (r *RequestBuilder) JSON(v interface{}) *RequestBuilder {
+	r.kind = bodyJson
+	r.json = v
+	return r
+}
+
+// Add a field to a multipart/form-data body
+func (r *RequestBuilder) Field(name, value string) *RequestBuilder {
+	r.kind = bodyMultipart
+	r.parts = append(r.parts, multipartPart{name: name, value: value})
+	return r
+}
+
+/*
+	Add a file to a multipart/form-data body, content is read into memory
+	straight away so the request can be sent again
+*/
+func (r *RequestBuilder) File(name, filename string, content io.Reader) *RequestBuilder {
+	r.kind = bodyMultipart
+	data, err := io.ReadAll(content)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	r.parts = append(r.parts, multipartPart{name: name, filename: filename, content: data})
+	return r
+}
+
+/*
+	Send the request and return the http response, the caller must close
+	its body. The browser stays on its current page.
+*/
+func (r *RequestBuilder) Send() (*http.Response, error) {
+	req, err := r.build()
+	if err != nil {
+		return nil, err
+	}
+	return r.browser.do(req)
+}
+
+// Send the request and read the whole body without parsing it
+func (r *RequestBuilder) Response() (*Response, error) {
+	resp, err := r.Send()
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+/*
+	Send the request and parse the response as a page, the browser
+	moves to it as it does for Load. Failing to follow a meta refresh
+	or script redirect on the page is returned as an error.
+*/
+func (r *RequestBuilder) Page() (*element.Page, error) {
+	resp, err := r.Send()
+	if err != nil {
+		return nil, err
+	}
+	return r.browser.toPage(resp)
+}
+
+func (r *RequestBuilder) build() (*http.Request, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	target, err := url.Parse(FixProtocol(r.target))
+	if err != nil {
+		return nil, err
+	}
+	// The existing query is kept exactly as written, servers can care about order and escaping
+	if len(r.query) > 0 {
+		if target.RawQuery != "" {
+			target.RawQuery += "&"
+		}
+		target.RawQuery += r.query.Encode()
+	}
+
+	body, contentType, err := r.encodeBody()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := r.headers.Clone()
+	if contentType != "" && headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", contentType)
+	}
+	if r.kind == bodyJson && headers.Get("Accept") == "" {
+		headers.Set("Accept", "application/json")
+	}
+
+	req, err := r.browser.newRequest(r.method, target.String(), body, headers)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(r.ctx), nil
+}
+
+/*
+	Encode the body into memory where possible, so it can be sent again
+	if the server answers with an auth challenge
+*/
+func (r *RequestBuilder) encodeBody() (io.Reader, string, error) {
+	switch r.kind {
+	case bodyRaw:
+		return r.raw, r.contentType, nil
+	case bodyForm:
+		return strings.NewReader(r.form.Encode()), "application/x-www-form-urlencoded", nil
+	case bodyJson:
+		encoded, err := json.Marshal(r.json)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(encoded), "application/json", nil
+	case bodyMultipart:
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		for _, part := range r.parts {
+			if part.content == nil {
+				if err := writer.WriteField(part.name, part.value); err != nil {
+					return nil, "", err
+				}
+				continue
+			}
+			file, err := writer.CreateFormFile(part.name, part.filename)
+			if err != nil {
+				return nil, "", err
+			}
+			if _, err := file.Write(part.content); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(buf.Bytes()), writer.FormDataContentType(), nil
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,142 @@
+package browser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var ErrStatus = errors.New("grawl: unexpected status")
+
+/*
+	The error for an unsuccessful Response, it matches ErrStatus with
+	errors.Is and keeps the response so error bodies from apis can be read.
+	Example:
+	var status *StatusError
+	if errors.As(err, &status) {
+		log.Println(status.Response.StatusCode, status.Response.Text())
+	}
+*/
+type StatusError struct {
+	Response *Response
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s from %s", ErrStatus, e.Response.Status, e.Response.Url)
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrStatus
+}
+
+/*
+	A Response is a fetched url with its body read into memory and not
+	parsed as a page, for apis, images and other files.
+*/
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// The url the body came from, after any redirects
+	Url  string
+	Body []byte
+}
+
+// Read a http response into a Response, closing its body
+func readResponse(resp *http.Response) (*Response, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	r := Response{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Url:        resp.Request.URL.String(),
+		Body:       body,
+	}
+	return &r, nil
+}
+
+// Was the request successful (a 2xx status)
+func (r *Response) Ok() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Return a *StatusError if the request wasn't successful
+func (r *Response) Check() error {
+	if r.Ok() {
+		return nil
+	}
+	return &StatusError{r}
+}
+
+func (r *Response) Text() string {
+	return string(r.Body)
+}
+
+// Decode the body as json into v
+func (r *Response) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+/*
+	Fetch a url and read the whole body without parsing it as a page.
+	Any status is returned as a Response, use Check to treat
+	unsuccessful ones as errors.
+	Example:
+	resp, err := b.Get("https://example.com/logo.png", nil)
+	if err == nil && resp.Ok() {
+		os.WriteFile("logo.png", resp.Body, 0644)
+	}
+*/
+func (b *Browser) Get(url string, headers http.Header) (*Response, error) {
+	resp, err := b.Fetch(url, headers)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+/*
+	Fetch a json api and decode its response into v,
+	a status other than 2xx is returned as a *StatusError holding the response
+	Example:
+	var results struct{ Items []Item `json:"items"` }
+	err := b.GetJSON("https://example.com/api/search?q=tea", &results)
+*/
+func (b *Browser) GetJSON(url string, v interface{}) error {
+	return b.sendJSON("GET", url, nil, v)
+}
+
+/*
+	Post body encoded as json and decode the json response into v,
+	v may be nil if the response isn't wanted
+	Example: err := b.PostJSON(api+"/basket", Item{Id: 4, Qty: 2}, &basket)
+*/
+func (b *Browser) PostJSON(url string, body interface{}, v interface{}) error {
+	return b.sendJSON("POST", url, body, v)
+}
+
+func (b *Browser) sendJSON(method, url string, body interface{}, v interface{}) error {
+	req := b.Request(method, url).Header("Accept", "application/json")
+	if body != nil {
+		req.JSON(body)
+	}
+	resp, err := req.Response()
+	if err != nil {
+		return err
+	}
+	if err := resp.Check(); err != nil {
+		return err
+	}
+	if v == nil || len(bytes.TrimSpace(resp.Body)) == 0 {
+		return nil
+	}
+	return resp.JSON(v)
+}
@@ -0,0 +1,301 @@
+package browser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/tlowry/grawl/util"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One page listed in a sitemap
+type SitemapEntry struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string
+	// 0.5 when the sitemap doesn't say, as the sitemap protocol defines
+	Priority float64
+	// The sitemap the entry was listed in
+	Sitemap string
+}
+
+// The formats the sitemap protocol allows for lastmod (W3C datetime)
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+/*
+	Iterate over every page listed in a sitemap, reading it as it
+	downloads so very large sitemaps never sit in memory. Sitemap index
+	files are followed into the sitemaps they list, as long as those are
+	on the same host as the index. Xml, gzipped xml and plain text (one
+	url per line) sitemaps are all understood.
+	A sitemap which fails to load is reported as an error and iteration
+	carries on with the next one.
+	Example:
+	for entry, err := range b.Sitemap("https://example.com/sitemap.xml") {
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		queue = append(queue, entry.Loc)
+	}
+*/
+func (b *Browser) Sitemap(sitemapUrl string) iter.Seq2[SitemapEntry, error] {
+	return b.sitemaps([]string{FixProtocol(sitemapUrl)})
+}
+
+/*
+	Iterate over the pages in every sitemap a site lists in its robots.txt,
+	falling back to /sitemap.xml if it lists none. If robots.txt can't be
+	fetched at all, rather than just not being there, that error is the
+	only thing returned.
+	Example: for entry, err := range b.DiscoverSitemaps("example.com") {...}
+*/
+func (b *Browser) DiscoverSitemaps(site string) iter.Seq2[SitemapEntry, error] {
+	return func(yield func(SitemapEntry, error) bool) {
+		sitemaps, err := b.SitemapsFromRobots(site)
+		// A site without a robots.txt (any 4xx) still often has a sitemap
+		var status *StatusError
+		if err != nil && !(errors.As(err, &status) && status.Response.StatusCode < 500) {
+			yield(SitemapEntry{}, fmt.Errorf("grawl: robots.txt for %s: %w", site, err))
+			return
+		}
+		if len(sitemaps) == 0 {
+			root, err := url.Parse(FixProtocol(site))
+			if err != nil {
+				yield(SitemapEntry{}, err)
+				return
+			}
+			sitemaps = []string{root.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+		}
+		b.sitemaps(sitemaps)(yield)
+	}
+}
+
+// Return the sitemap urls listed in a sites robots.txt
+func (b *Browser) SitemapsFromRobots(site string) ([]string, error) {
+	root, err := url.Parse(FixProtocol(site))
+	if err != nil {
+		return nil, err
+	}
+	robots := root.ResolveReference(&url.URL{Path: "/robots.txt"})
+
+	body, err := b.fetchSitemap(robots.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	sitemaps := []string{}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+			continue
+		}
+		// Drop any trailing comment
+		value = strings.TrimSpace(strings.SplitN(value, "#", 2)[0])
+		if ref, err := robots.Parse(value); err == nil && value != "" {
+			sitemaps = append(sitemaps, ref.String())
+		}
+	}
+	return sitemaps, scanner.Err()
+}
+
+// Read each sitemap in turn, following index files and skipping ones already read
+func (b *Browser) sitemaps(pending []string) iter.Seq2[SitemapEntry, error] {
+	return func(yield func(SitemapEntry, error) bool) {
+		seen := map[string]bool{}
+		for len(pending) > 0 {
+			current := pending[0]
+			pending = pending[1:]
+			if seen[current] {
+				continue
+			}
+			seen[current] = true
+
+			stopped := false
+			err := b.readSitemapUrl(current, func(entry SitemapEntry, isIndex bool) bool {
+				if isIndex {
+					// The sitemap protocol only lets an index list sitemaps on its own host
+					if !sameHost(current, entry.Loc) {
+						err := fmt.Errorf("grawl: sitemap %s: not following %s on another host", current, entry.Loc)
+						stopped = !yield(SitemapEntry{}, err)
+						return !stopped
+					}
+					pending = append(pending, entry.Loc)
+					return true
+				}
+				if !yield(entry, nil) {
+					stopped = true
+					return false
+				}
+				return true
+			})
+			if stopped {
+				return
+			}
+			if err != nil && !yield(SitemapEntry{}, fmt.Errorf("grawl: sitemap %s: %w", current, err)) {
+				return
+			}
+		}
+	}
+}
+
+func (b *Browser) readSitemapUrl(sitemapUrl string, found func(SitemapEntry, bool) bool) error {
+	body, err := b.fetchSitemap(sitemapUrl)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return readSitemap(body, sitemapUrl, found)
+}
+
+func (b *Browser) fetchSitemap(target string) (io.ReadCloser, error) {
+	resp, err := b.Fetch(target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		failed := Response{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Url: target}
+		return nil, &StatusError{&failed}
+	}
+	return resp.Body, nil
+}
+
+func sameHost(a, b string) bool {
+	first, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	second, err := url.Parse(b)
+	return err == nil && strings.EqualFold(first.Host, second.Host)
+}
+
+/*
+	Parse a sitemap, calling found for each entry with isIndex set for
+	entries pointing at further sitemaps. Stops early if found returns false.
+*/
+func readSitemap(r io.Reader, source string, found func(entry SitemapEntry, isIndex bool) bool) error {
+	in := bufio.NewReader(r)
+
+	// Gzipped sitemaps are often served without a Content-Encoding
+	if magic, _ := in.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		unzipped, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		in = bufio.NewReader(unzipped)
+	}
+
+	// Skip a byte order mark and leading space to tell xml from text
+	for {
+		ch, _, err := in.ReadRune()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if ch != '\uFEFF' && !strings.ContainsRune(" \t\r\n", ch) {
+			in.UnreadRune()
+			if ch != '<' {
+				return readTextSitemap(in, source, found)
+			}
+			break
+		}
+	}
+	return readXmlSitemap(in, source, found)
+}
+
+func readTextSitemap(r io.Reader, source string, found func(SitemapEntry, bool) bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		loc := strings.TrimSpace(scanner.Text())
+		if loc == "" {
+			continue
+		}
+		if !found(SitemapEntry{Loc: loc, Priority: 0.5, Sitemap: source}, false) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func readXmlSitemap(r io.Reader, source string, found func(SitemapEntry, bool) bool) error {
+	type xmlEntry struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+	}
+
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = util.CharsetReader
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || (start.Name.Local != "url" && start.Name.Local != "sitemap") {
+			continue
+		}
+
+		raw := xmlEntry{}
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			return err
+		}
+		entry := SitemapEntry{
+			Loc:        strings.TrimSpace(raw.Loc),
+			ChangeFreq: strings.ToLower(strings.TrimSpace(raw.ChangeFreq)),
+			Priority:   0.5,
+			Sitemap:    source,
+		}
+		if entry.Loc == "" {
+			continue
+		}
+		if priority, err := strconv.ParseFloat(strings.TrimSpace(raw.Priority), 64); err == nil {
+			entry.Priority = priority
+		}
+		entry.LastMod = parseLastMod(raw.LastMod)
+
+		if !found(entry, start.Name.Local == "sitemap") {
+			return nil
+		}
+	}
+}
+
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -1,16 +1,41 @@
 package element
 
 import (
+	"errors"
 	"github.com/hishboy/gocommons/lang"
+	"iter"
+	"strings"
 	"sync"
 )
 
+var (
+	ErrFrozen    = errors.New("grawl: element is frozen and can't be changed")
+	ErrNotChild  = errors.New("grawl: element is not a child of this element")
+	ErrHierarchy = errors.New("grawl: an element can't be moved inside itself")
+)
+
 /*
 	Define common methods for HTML elements
+
+	Elements are safe to use from multiple goroutines. Every method locks
+	the element it reads or changes, so queries can run alongside each
+	other and alongside changes. A change which touches several elements,
+	such as moving a child to a new parent, locks them one at a time, so a
+	concurrent query may see the tree part way through that change.
+	Callers that need a stable view while others write should query a
+	frozen copy from Freeze instead, which needs no locking at all.
 */
 type Element interface {
 	AddChild(child Element)
+	Prepend(child Element)
+	InsertBefore(newChild, refChild Element)
+	InsertAfter(newChild, refChild Element)
 	RemoveChild(child Element)
+	ReplaceWith(other Element)
+	Wrap(wrapper Element)
+	Unwrap()
+	Detach()
+	Clone() Element
 	GetParent() Element
 	setParent(newParent Element)
 	GetAttribute(key string) string
@@ -21,16 +46,24 @@ type Element interface {
 	GetChildren() []Element
 	GetContent() string
 	SetContent(string)
+	GetTail() string
+	SetTail(string)
 	String() string
 	GetAttributes() map[string]string
-	ByAttribute(name, value string) Element
-	AllByAttribute(name, value string) []Element
-	ById(id string) Element
-	AllById(id string) []Element
-	ByClass(class string) Element
-	AllByClass(class string) []Element
-	ByTag(tag string) Element
-	AllByTag(tag string) []Element
+	ByAttribute(name string, value interface{}) Element
+	AllByAttribute(name string, value interface{}) []Element
+	ById(id interface{}) Element
+	AllById(id interface{}) []Element
+	ByClass(class interface{}) Element
+	AllByClass(class interface{}) []Element
+	ByClasses(classes ...string) Element
+	AllByClasses(classes ...string) []Element
+	HasClass(class string) bool
+	AddClass(class string)
+	RemoveClass(class string)
+	ToggleClass(class string) bool
+	ByTag(tag interface{}) Element
+	AllByTag(tag interface{}) []Element
 	GetMutex() *sync.RWMutex
 	GetKind() ElemKind
 	SetKind(ElemKind)
@@ -38,6 +71,16 @@ type Element interface {
 	setNext(Element)
 	Prev() Element
 	setPrev(Element)
+	NextSibling() Element
+	PrevSibling() Element
+	Index() int
+	Descendants() iter.Seq[Element]
+	Ancestors() iter.Seq[Element]
+	Closest(v Validator) Element
+	ToMarkdown() string
+	ToText() string
+	IsFrozen() bool
+	base() *BaseElement
 }
 
 /*
@@ -52,8 +95,11 @@ type BaseElement struct {
 	mutex      *sync.RWMutex
 	kind       ElemKind
 	content    string
+	tail       string
 	next       Element
 	prev       Element
+	frozen     bool
+	self       Element
 }
 
 func NewBaseElement() *BaseElement {
@@ -64,38 +110,245 @@ func NewBaseElement() *BaseElement {
 	e.children = []Element{}
 	e.mutex = &sync.RWMutex{}
 	e.kind = ELEM_NORMAL
+	e.self = &e
 	return &e
 }
 
+/*
+	Return the Element this BaseElement is part of, such as the Form
+	embedding it, so parent links and search results keep their real type
+*/
+func (e *BaseElement) elem() Element {
+	if e.self != nil && e.self.base() == e {
+		return e.self
+	}
+	return e
+}
+
 // Append a child to a node and remove it from its old parent if it has one
 func (e *BaseElement) AddChild(child Element) {
+	e.insertChild(child, nil, true)
+}
 
-	// Remove child from current parent if needed
-	currentParent := child.GetParent()
-	if currentParent != nil {
-		currentParent.RemoveChild(child)
+// Insert a child as the first child of this element
+func (e *BaseElement) Prepend(child Element) {
+	e.insertChild(child, nil, false)
+}
+
+/*
+	Insert newChild into this elements children just before refChild,
+	removing it from its old parent first. A nil refChild appends.
+	Example: put a heading above the first paragraph
+	body.InsertBefore(heading, body.ByTag("p"))
+*/
+func (e *BaseElement) InsertBefore(newChild, refChild Element) {
+	if refChild == nil {
+		e.insertChild(newChild, nil, true)
+		return
 	}
+	e.insertChild(newChild, refChild, false)
+}
 
-	// Make this child a sibling of existing children
-	children := e.GetChildren()
-	if len(children) > 0 {
-		lastElem := children[len(children)-1]
-		child.setPrev(lastElem)
+/*
+	Insert newChild into this elements children just after refChild,
+	removing it from its old parent first. A nil refChild prepends.
+*/
+func (e *BaseElement) InsertAfter(newChild, refChild Element) {
+	if refChild == nil {
+		e.insertChild(newChild, nil, false)
+		return
 	}
-	e.children = append(e.children, child)
-	child.setNext(nil)
-	child.setParent(e)
+	e.insertChild(newChild, refChild, true)
 }
 
-// Remove a child and all its children from a given elements tree
+/*
+	Remove a child and all its children from a given elements tree.
+	The text following the child stays where it was.
+*/
 func (e *BaseElement) RemoveChild(child Element) {
-	for i, c := range e.GetChildren() {
+	tail := child.GetTail()
+
+	e.lock()
+	found := false
+	var prev, next Element
+	for i, c := range e.children {
 		if child == c {
-			// TODO need to lock here
-			e.children = append(e.children[:i], e.children[i+1:]...)
-			child.setParent(nil)
+			if i > 0 {
+				prev = e.children[i-1]
+			}
+			if i < len(e.children)-1 {
+				next = e.children[i+1]
+			}
+			e.children = append(e.children[:i:i], e.children[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if found && prev == nil {
+		e.content += tail
+	}
+	e.unlock()
+
+	if !found {
+		return
+	}
+
+	// Close the gap left between the old siblings
+	child.SetTail("")
+	if prev != nil {
+		prev.SetTail(prev.GetTail() + tail)
+		prev.setNext(next)
+	}
+	if next != nil {
+		next.setPrev(prev)
+	}
+	child.setParent(nil)
+	child.setPrev(nil)
+	child.setNext(nil)
+}
+
+/*
+	Put another element where this one is in the tree, this element
+	is removed along with its children.
+	Does nothing if this element has no parent.
+*/
+func (e *BaseElement) ReplaceWith(other Element) {
+	parent := e.GetParent()
+	if parent == nil || other == e.elem() {
+		return
+	}
+	parent.InsertBefore(other, e.elem())
+	parent.RemoveChild(e.elem())
+}
+
+/*
+	Put wrapper where this element is and move this element inside it
+	Example: wrap a table so it can scroll
+	div := element.NewBaseElement()
+	div.SetTagName("div")
+	table.Wrap(div)
+*/
+func (e *BaseElement) Wrap(wrapper Element) {
+	if parent := e.GetParent(); parent != nil {
+		parent.InsertBefore(wrapper, e.elem())
+	}
+	wrapper.AddChild(e.elem())
+}
+
+/*
+	Replace this element with its own children and text, the opposite
+	of Wrap. Does nothing if this element has no parent.
+*/
+func (e *BaseElement) Unwrap() {
+	parent := e.GetParent()
+	if parent == nil {
+		return
+	}
+
+	e.rlock()
+	leading := e.content
+	children := append([]Element(nil), e.children...)
+	e.runlock()
+
+	// The text at the start of this element goes just before it
+	if prev := e.Prev(); prev != nil {
+		prev.SetTail(prev.GetTail() + leading)
+	} else {
+		parent.base().appendContent(leading)
+	}
+
+	for _, child := range children {
+		tail := child.GetTail()
+		parent.InsertBefore(child, e.elem())
+		child.SetTail(tail)
+	}
+	parent.RemoveChild(e.elem())
+}
+
+// Remove this element and its children from its parent
+func (e *BaseElement) Detach() {
+	if parent := e.GetParent(); parent != nil {
+		parent.RemoveChild(e.elem())
+	}
+}
+
+/*
+	Make a deep copy of this element and its children with no parent.
+	Forms and inputs in the copy keep their concrete types.
+*/
+func (e *BaseElement) Clone() Element {
+	return copyTree(e.elem())
+}
+
+/*
+	Insert child next to ref (after it if after is true) or at one end
+	of the children when ref is nil, fixing up all the sibling links
+*/
+func (e *BaseElement) insertChild(child, ref Element, after bool) {
+	self := e.elem()
+	if child == ref {
+		return
+	}
+
+	// Check before touching anything so a refused insert leaves both trees whole
+	if e.frozen || child.IsFrozen() {
+		panic(ErrFrozen)
+	}
+
+	// Refuse to create a loop by moving an ancestor inside its descendant
+	for ancestor := self; ancestor != nil; ancestor = ancestor.GetParent() {
+		if ancestor == child {
+			panic(ErrHierarchy)
 		}
 	}
+
+	// Remove child from current parent if needed
+	child.Detach()
+
+	e.lock()
+	index := -1
+	if ref == nil {
+		if after {
+			index = len(e.children)
+		} else {
+			index = 0
+		}
+	} else {
+		for i, c := range e.children {
+			if c == ref {
+				index = i
+				if after {
+					index++
+				}
+				break
+			}
+		}
+	}
+
+	if index < 0 {
+		e.unlock()
+		panic(ErrNotChild)
+	}
+
+	var prev, next Element
+	if index > 0 {
+		prev = e.children[index-1]
+	}
+	if index < len(e.children) {
+		next = e.children[index]
+	}
+	e.children = append(e.children[:index:index], append([]Element{child}, e.children[index:]...)...)
+	e.unlock()
+
+	child.setPrev(prev)
+	child.setNext(next)
+	child.setParent(self)
+	if prev != nil {
+		prev.setNext(child)
+	}
+	if next != nil {
+		next.setPrev(child)
+	}
 }
 
 /*
@@ -105,11 +358,15 @@ func (e *BaseElement) RemoveChild(child Element) {
 */
 
 func (e *BaseElement) setParent(newParent Element) {
+	e.lock()
+	defer e.unlock()
 	e.parent = newParent
 }
 
 // Return this childs current parent element
 func (e *BaseElement) GetParent() Element {
+	e.rlock()
+	defer e.runlock()
 	return e.parent
 }
 
@@ -119,23 +376,46 @@ func (e *BaseElement) GetParent() Element {
 	styleStr := elem.GetAttribute("style")
 */
 func (e *BaseElement) GetAttribute(key string) string {
+	e.rlock()
+	defer e.runlock()
 	return e.attributes[key]
 }
 
+/*
+	Return an attribute and whether the element has it at all,
+	without copying every attribute as GetAttributes does
+*/
+func (e *BaseElement) lookupAttribute(key string) (string, bool) {
+	e.rlock()
+	defer e.runlock()
+	val, ok := e.attributes[key]
+	return val, ok
+}
+
 /*
 	Set an Elements tag attribute
 	Example: Use the style attribute to hide the element
 	elem.SetAttribute("style","display:none")
 */
 func (e *BaseElement) SetAttribute(key, value string) {
+	e.lock()
+	defer e.unlock()
 	e.attributes[key] = value
 }
 
 /*
 	Get all tag attributes belonging to an element
+	(a copy, changing it doesn't change the element)
 */
 func (e *BaseElement) GetAttributes() map[string]string {
-	return e.attributes
+	e.rlock()
+	defer e.runlock()
+
+	attributes := make(map[string]string, len(e.attributes))
+	for key, val := range e.attributes {
+		attributes[key] = val
+	}
+	return attributes
 }
 
 /*
@@ -144,21 +424,29 @@ func (e *BaseElement) GetAttributes() map[string]string {
 	elem.RemoveAttribute("style")
 */
 func (e *BaseElement) RemoveAttribute(key string) {
+	e.lock()
+	defer e.unlock()
 	delete(e.attributes, key)
 }
 
 // Return all children directly below this element
 func (e *BaseElement) GetChildren() []Element {
-	return e.children
+	e.rlock()
+	defer e.runlock()
+	return append([]Element(nil), e.children...)
 }
 
 // Return the tag name for an element such as "img" or "div"
 func (e *BaseElement) GetTagName() string {
+	e.rlock()
+	defer e.runlock()
 	return e.tagName
 }
 
 // Set the tag name of this Element to the given name
 func (e *BaseElement) SetTagName(name string) {
+	e.lock()
+	defer e.unlock()
 	e.tagName = name
 }
 
@@ -183,17 +471,55 @@ func (e *BaseElement) String() string {
 /*
 	Returns text enclosed within this elements start and end tags
 	excluding any child tags.
+	Example: for <p>Hello <b>big</b> world</p> this is "Hello  world",
+	use Text to include the text of child tags too.
 */
 func (e *BaseElement) GetContent() string {
-	return e.content
+	e.rlock()
+	content := e.content
+	children := append([]Element(nil), e.children...)
+	e.runlock()
+
+	// Text between and after the children is held in their tails
+	for _, child := range children {
+		content += child.GetTail()
+	}
+	return content
 }
 
 /*
 	Sets the text enclosed within this elements start and end tags
 	excluding any child tags.
+	The text is put before any children, text which was between or
+	after them is removed.
 */
 func (e *BaseElement) SetContent(content string) {
+	e.lock()
 	e.content = content
+	children := append([]Element(nil), e.children...)
+	e.unlock()
+
+	for _, child := range children {
+		child.SetTail("")
+	}
+}
+
+/*
+	Returns the text following this elements end tag, up to the next
+	tag in its parent.
+	Example: for <p>Hello <b>big</b> world</p> the tail of b is " world"
+*/
+func (e *BaseElement) GetTail() string {
+	e.rlock()
+	defer e.runlock()
+	return e.tail
+}
+
+// Sets the text following this elements end tag
+func (e *BaseElement) SetTail(tail string) {
+	e.lock()
+	defer e.unlock()
+	e.tail = tail
 }
 
 /*
@@ -202,19 +528,18 @@ func (e *BaseElement) SetContent(content string) {
 	Usually used when there is only one of these
 	elements on a page or any one of them will do.
 
+	The value, like that of every query method, is either a string
+	which must match exactly, a Matcher or a *regexp.Regexp.
+
 	Example: Return the first link with a url
 	pointing to /search
 	link := elem.ByAttribute("href", "/search")
-*/
-func (e *BaseElement) ByAttribute(name, value string) Element {
-	val, err := NewAttributeValidator(name, value)
 
-	if err == nil {
-		elem := BFSFirst(e, val)
-		return elem
-	}
-
-	panic(err)
+	Example: Return the first link to any search page
+	link := elem.ByAttribute("href", element.Prefix("/search"))
+*/
+func (e *BaseElement) ByAttribute(name string, value interface{}) Element {
+	return BFSFirst(e.elem(), MatchAttribute(name, toMatcher(value)))
 }
 
 /*
@@ -225,15 +550,8 @@ func (e *BaseElement) ByAttribute(name, value string) Element {
 	find all links where the url points to submit
 	elem.ByAttribute("href", "/submit")
 */
-func (e *BaseElement) AllByAttribute(name, value string) []Element {
-	val, err := NewAttributeValidator(name, value)
-
-	if err == nil {
-		return BFS(e, val)
-	}
-
-	panic(err)
-
+func (e *BaseElement) AllByAttribute(name string, value interface{}) []Element {
+	return BFS(e.elem(), MatchAttribute(name, toMatcher(value)))
 }
 
 /*
@@ -244,14 +562,8 @@ func (e *BaseElement) AllByAttribute(name, value string) []Element {
 	Find the first (usually only) search box div
 	box := elem.ById("search-box")
 */
-func (e *BaseElement) ById(id string) Element {
-	val, err := NewAttributeValidator("id", id)
-	if err == nil {
-		return BFSFirst(e, *val)
-	}
-
-	panic(err)
-
+func (e *BaseElement) ById(id interface{}) Element {
+	return BFSFirst(e.elem(), MatchAttribute("id", toMatcher(id)))
 }
 
 /*
@@ -262,31 +574,22 @@ func (e *BaseElement) ById(id string) Element {
 	find all links where the url points to submit
 	elem := elem.ById("search-result")
 */
-func (e *BaseElement) AllById(id string) []Element {
-	val, err := NewAttributeValidator("id", id)
-	if err == nil {
-		return BFS(e, val)
-	}
-
-	panic(err)
+func (e *BaseElement) AllById(id interface{}) []Element {
+	return BFS(e.elem(), MatchAttribute("id", toMatcher(id)))
 }
 
 /*
 	Return the first element found with the given
-	class name.
+	class name. Class names are matched whole, so "col" doesn't
+	match an element of class "col-md-6". A string holding several
+	space separated names requires all of them, like ByClasses.
 
 	Example:
 	Find the first (usually only) search box div
 	box := elem.ByClass("info-container")
 */
-func (e *BaseElement) ByClass(class string) Element {
-	val, err := NewAttributeValidator("class", "(^|.*\\s)"+class+"($|\\s.*)")
-	if err == nil {
-		return BFSFirst(e, *val)
-	}
-
-	panic(err)
-
+func (e *BaseElement) ByClass(class interface{}) Element {
+	return BFSFirst(e.elem(), classValidator(class))
 }
 
 /*
@@ -296,30 +599,103 @@ func (e *BaseElement) ByClass(class string) Element {
 	Example:
 	elem := elem.AllByClass("info-result")
 */
-func (e *BaseElement) AllByClass(class string) []Element {
+func (e *BaseElement) AllByClass(class interface{}) []Element {
+	return BFS(e.elem(), classValidator(class))
+}
 
-	val, err := NewAttributeValidator("class", "(^|\\s)"+class+"($|\\s)")
+/*
+	Return the first element which has all of the given classes
+	Example: find the main call to action
+	button := elem.ByClasses("btn", "btn-primary")
+*/
+func (e *BaseElement) ByClasses(classes ...string) Element {
+	return BFSFirst(e.elem(), MatchClasses(classes...))
+}
+
+// Return all elements which have all of the given classes
+func (e *BaseElement) AllByClasses(classes ...string) []Element {
+	return BFS(e.elem(), MatchClasses(classes...))
+}
 
-	if err == nil {
-		return BFS(e, val)
+func classValidator(class interface{}) *ClassValidator {
+	if names, ok := class.(string); ok && len(strings.Fields(names)) > 1 {
+		return MatchClasses(strings.Fields(names)...)
 	}
+	return MatchClass(toMatcher(class))
+}
 
-	panic(err)
+// Returns true if the class attribute holds this class name
+func (e *BaseElement) HasClass(class string) bool {
+	for _, c := range strings.Fields(e.GetAttribute("class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a class name to the class attribute unless it is already there
+func (e *BaseElement) AddClass(class string) {
+	e.lock()
+	defer e.unlock()
+
+	classes := strings.Fields(e.attributes["class"])
+	for _, c := range classes {
+		if c == class {
+			return
+		}
+	}
+	e.attributes["class"] = strings.Join(append(classes, class), " ")
+}
+
+// Remove every occurrence of a class name from the class attribute
+func (e *BaseElement) RemoveClass(class string) {
+	e.lock()
+	defer e.unlock()
+
+	if _, ok := e.attributes["class"]; !ok {
+		return
+	}
+	kept := []string{}
+	for _, c := range strings.Fields(e.attributes["class"]) {
+		if c != class {
+			kept = append(kept, c)
+		}
+	}
+	e.attributes["class"] = strings.Join(kept, " ")
 }
 
 /*
-	Return the first element with a matching tag
-	Example: find the first form in the document
-	form := elem.ByTag("form")
+	Add the class if it is missing or remove it if it is there,
+	returns true if the element has the class afterwards
 */
-func (e *BaseElement) ByTag(tag string) Element {
-	val, err := NewTagValidator(tag)
+func (e *BaseElement) ToggleClass(class string) bool {
+	e.lock()
+	defer e.unlock()
+
+	classes := strings.Fields(e.attributes["class"])
+	kept := []string{}
+	for _, c := range classes {
+		if c != class {
+			kept = append(kept, c)
+		}
+	}
 
-	if err == nil {
-		return BFSFirst(e, *val)
+	has := len(kept) == len(classes)
+	if has {
+		kept = append(kept, class)
 	}
+	e.attributes["class"] = strings.Join(kept, " ")
+	return has
+}
 
-	panic(err)
+/*
+	Return the first element with a matching tag
+	Example: find the first form in the document
+	form := elem.ByTag("form")
+*/
+func (e *BaseElement) ByTag(tag interface{}) Element {
+	return BFSFirst(e.elem(), MatchTag(toMatcher(tag)))
 }
 
 /*
@@ -327,48 +703,187 @@ func (e *BaseElement) ByTag(tag string) Element {
 	Example: find all forms in the document
 	forms := elem.ByTag("form")
 */
-func (e *BaseElement) AllByTag(tag string) []Element {
-	val, err := NewTagValidator(tag)
-
-	if err == nil {
-		return BFS(e, *val)
-	}
-
-	panic(err)
+func (e *BaseElement) AllByTag(tag interface{}) []Element {
+	return BFS(e.elem(), MatchTag(toMatcher(tag)))
 }
 
+/*
+	Return the lock guarding this element, every method already takes it
+	so callers only need it to make several calls on one element atomic.
+	Don't call other methods of the element while holding it.
+*/
 func (e *BaseElement) GetMutex() *sync.RWMutex {
 	return e.mutex
 }
 
 func (e *BaseElement) GetKind() ElemKind {
+	e.rlock()
+	defer e.runlock()
 	return e.kind
 }
 
 func (e *BaseElement) SetKind(elemKind ElemKind) {
+	e.lock()
+	defer e.unlock()
 	e.kind = elemKind
 }
 
 func (e *BaseElement) Next() Element {
+	e.rlock()
+	defer e.runlock()
 	return e.next
 }
 
 func (e *BaseElement) setNext(next Element) {
+	e.lock()
+	defer e.unlock()
 	e.next = next
 }
 
 func (e *BaseElement) Prev() Element {
+	e.rlock()
+	defer e.runlock()
 	return e.prev
 }
 
 func (e *BaseElement) setPrev(next Element) {
+	e.lock()
+	defer e.unlock()
 	e.prev = next
 }
 
-// Perform an iterative Breadth first search to find a matching element
+// Returns true if this element belongs to a frozen copy made by Freeze
+func (e *BaseElement) IsFrozen() bool {
+	return e.frozen
+}
+
+func (e *BaseElement) base() *BaseElement {
+	return e
+}
+
+// The text before this elements first child
+func (e *BaseElement) leadingText() string {
+	e.rlock()
+	defer e.runlock()
+	return e.content
+}
+
+// Add to the text before this elements first child, leaving the rest alone
+func (e *BaseElement) appendContent(text string) {
+	e.lock()
+	defer e.unlock()
+	e.content += text
+}
+
+/*
+	Frozen elements never change so they are read without locking,
+	any attempt to change one panics with ErrFrozen
+*/
+func (e *BaseElement) rlock() {
+	if !e.frozen {
+		e.mutex.RLock()
+	}
+}
+
+func (e *BaseElement) runlock() {
+	if !e.frozen {
+		e.mutex.RUnlock()
+	}
+}
+
+func (e *BaseElement) lock() {
+	if e.frozen {
+		panic(ErrFrozen)
+	}
+	e.mutex.Lock()
+}
+
+func (e *BaseElement) unlock() {
+	e.mutex.Unlock()
+}
+
+/*
+	Make an immutable deep copy of an element and everything below it.
+	The copy is read without any locking so it is the fastest way to
+	fan a page out to many goroutines, changing it panics with ErrFrozen.
+	Example: frozen := element.Freeze(page.ById("results"))
+*/
+func Freeze(e Element) Element {
+	if e.IsFrozen() {
+		return e
+	}
+
+	root := copyTree(e)
+
+	// Only mark the copy frozen once it is fully built
+	stack := []Element{root}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		stack = append(stack, current.GetChildren()...)
+		current.base().frozen = true
+	}
+	return root
+}
+
+// Copy an element and all of its children keeping their concrete types
+func copyTree(e Element) Element {
+	type pair struct {
+		src, dst Element
+	}
+
+	root := copyElement(e)
+	// The text after the element isn't part of it
+	root.base().tail = ""
+
+	stack := []pair{{e, root}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, child := range p.src.GetChildren() {
+			c := copyElement(child)
+			p.dst.AddChild(c)
+			stack = append(stack, pair{child, c})
+		}
+	}
+	return root
+}
+
+// Copy a single element without its parent or children
+func copyElement(e Element) Element {
+	var c Element
+	switch e.(type) {
+	case *Form:
+		c = NewForm()
+	case *Input:
+		c = NewInput()
+	default:
+		c = NewBaseElement()
+	}
+
+	src := e.base()
+	src.rlock()
+	defer src.runlock()
+
+	dst := c.base()
+	dst.tagName = src.tagName
+	dst.data = src.data
+	dst.kind = src.kind
+	dst.content = src.content
+	dst.tail = src.tail
+	for key, val := range src.attributes {
+		dst.attributes[key] = val
+	}
+	return c
+}
+
+/*
+	Perform an iterative Breadth first search to find a matching element.
+	The validator is left as it is so it can be shared between goroutines.
+*/
 func BFSFirst(current Element, v Validator) Element {
-	v.SetFirstOnly(true)
-	results := BFS(current, v)
+	results := bfs(current, v, -1, true)
 	if len(results) > 0 {
 		return results[0]
 	}
@@ -381,9 +896,31 @@ func BFSFirst(current Element, v Validator) Element {
 	will be
 */
 func BFS(current Element, v Validator) []Element {
+	return BFSDepth(current, v, -1)
+}
+
+/*
+	Perform a Breadth first search which goes no more than maxDepth levels
+	below the given element, 0 only checks the element itself and a
+	negative depth searches the whole tree.
+	Example: find the links directly inside a menu
+	v, _ := element.NewTagValidator("a")
+	links := element.BFSDepth(menu, v, 1)
+*/
+func BFSDepth(current Element, v Validator, maxDepth int) []Element {
+	return bfs(current, v, maxDepth, v.FirstOnly())
+}
+
+// The search behind BFS, stopping at the first match if firstOnly is set
+func bfs(current Element, v Validator, maxDepth int, firstOnly bool) []Element {
+	type queued struct {
+		elem  Element
+		depth int
+	}
+
 	queue := lang.NewQueue()
 
-	queue.Push(current)
+	queue.Push(queued{current, 0})
 
 	results := []Element{}
 
@@ -392,20 +929,22 @@ func BFS(current Element, v Validator) []Element {
 		tmp := queue.Poll()
 
 		if tmp != nil {
-			current = tmp.(Element)
-			//current.GetMutex().RLock()
+			q := tmp.(queued)
+			current = q.elem
 
+			// Each call on current takes its lock, so none is held here
 			if v.Validate(current) {
 				results = append(results, current)
-				if v.FirstOnly() {
+				if firstOnly {
 					break
 				}
 			}
-			for _, child := range current.GetChildren() {
-				queue.Push(child)
-			}
 
-			//current.GetMutex().RUnlock()
+			if maxDepth < 0 || q.depth < maxDepth {
+				for _, child := range current.GetChildren() {
+					queue.Push(queued{child, q.depth + 1})
+				}
+			}
 
 		} else {
 
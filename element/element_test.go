@@ -1,7 +1,889 @@
 package element
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><title>Test</title></head><body>
+<div id="main" class="content">
+	<p class="intro">first</p>
+	<p>second</p>
+	<form id="login" action="/login"><input name="user" value=""><input name="pass" value=""></form>
+</div>
+<ul id="list"><li>a</li><li>b</li><li>c</li></ul>
+</body></html>`
+
+func parseTestPage() *Page {
+	return ParseBody(strings.NewReader(testPage))
+}
 
 func TestElement(t *testing.T) {
+	page := parseTestPage()
+	if page.ById("main") == nil {
+		t.Fatal("couldn't find #main")
+	}
+	if len(page.root.AllByTag("li")) != 3 {
+		t.Fatal("expected 3 list items")
+	}
+}
+
+// Run with -race, queries and changes from many goroutines at once
+func TestConcurrentAccess(t *testing.T) {
+	page := parseTestPage()
+	list := page.ById("list")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				page.AllByClass("intro")
+				page.root.AllByTag("li")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				li := NewBaseElement()
+				li.SetTagName("li")
+				list.AddChild(li)
+				li.SetAttribute("class", "added")
+				list.RemoveChild(li)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				page.ById("main").SetAttribute("data-n", "x")
+				page.ById("main").GetAttributes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(list.GetChildren()) != 3 {
+		t.Fatalf("expected the list to be back to 3 items, got %d", len(list.GetChildren()))
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	page := parseTestPage()
+	frozen := page.Freeze()
+
+	form, ok := frozen.ById("login").(*Form)
+	if !ok {
+		t.Fatal("frozen copy lost the Form type")
+	}
+	if len(form.GetInputs()) != 2 || !form.IsFrozen() {
+		t.Fatal("frozen form is incomplete")
+	}
+
+	// Changes to the original don't reach the copy
+	page.ById("main").SetAttribute("class", "changed")
+	if frozen.ById("main").GetAttribute("class") != "content" {
+		t.Fatal("frozen copy changed with the original")
+	}
+
+	// A refused insert leaves the parent as it was
+	parent := page.ById("main")
+	before := len(parent.GetChildren())
+	func() {
+		defer func() {
+			if recover() != ErrFrozen {
+				t.Fatal("expected adding a frozen child to panic with ErrFrozen")
+			}
+		}()
+		parent.AddChild(frozen.ById("login"))
+	}()
+	if len(parent.GetChildren()) != before {
+		t.Fatal("refused insert changed the parent")
+	}
+	checkLinks(t, parent)
+
+	// Searching for the first match doesn't change a shared validator
+	v, _ := NewTagValidator("input")
+	if BFSFirst(form, v) == nil || len(BFS(form, v)) != 2 || v.FirstOnly() {
+		t.Fatal("expected BFSFirst to leave the validator alone")
+	}
+
+	defer func() {
+		if recover() != ErrFrozen {
+			t.Fatal("expected changing a frozen element to panic with ErrFrozen")
+		}
+	}()
+	frozen.ById("main").SetAttribute("class", "x")
+}
+
+func newTestElem(tag string) Element {
+	e := NewBaseElement()
+	e.SetTagName(tag)
+	return e
+}
+
+// Check the parent, next and prev links of every child agree with the child slice
+func checkLinks(t *testing.T, parent Element) {
+	t.Helper()
+	children := parent.GetChildren()
+	for i, c := range children {
+		if c.GetParent() != parent {
+			t.Fatalf("child %d of %s has parent %v", i, parent.GetTagName(), c.GetParent())
+		}
+		var prev, next Element
+		if i > 0 {
+			prev = children[i-1]
+		}
+		if i < len(children)-1 {
+			next = children[i+1]
+		}
+		if c.Prev() != prev || c.Next() != next {
+			t.Fatalf("child %d (%s) has broken sibling links", i, c.GetTagName())
+		}
+	}
+}
+
+func tags(e Element) string {
+	names := []string{}
+	for _, c := range e.GetChildren() {
+		names = append(names, c.GetTagName())
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMutation(t *testing.T) {
+	root := newTestElem("div")
+	a, b, c := newTestElem("a"), newTestElem("b"), newTestElem("c")
+	root.AddChild(a)
+	root.AddChild(c)
+	root.InsertBefore(b, c)
+	root.Prepend(newTestElem("first"))
+	root.InsertAfter(newTestElem("last"), c)
+	if tags(root) != "first,a,b,c,last" {
+		t.Fatalf("unexpected order %s", tags(root))
+	}
+	checkLinks(t, root)
+
+	root.RemoveChild(b)
+	if tags(root) != "first,a,c,last" || b.GetParent() != nil || b.Next() != nil || b.Prev() != nil {
+		t.Fatalf("remove left %s", tags(root))
+	}
+	checkLinks(t, root)
+
+	a.ReplaceWith(b)
+	if tags(root) != "first,b,c,last" || a.GetParent() != nil {
+		t.Fatalf("replace left %s", tags(root))
+	}
+	checkLinks(t, root)
+
+	wrapper := newTestElem("span")
+	c.Wrap(wrapper)
+	if tags(root) != "first,b,span,last" || tags(wrapper) != "c" {
+		t.Fatalf("wrap left %s", tags(root))
+	}
+	checkLinks(t, root)
+	checkLinks(t, wrapper)
+
+	wrapper.Unwrap()
+	if tags(root) != "first,b,c,last" || wrapper.GetParent() != nil {
+		t.Fatalf("unwrap left %s", tags(root))
+	}
+	checkLinks(t, root)
+
+	c.Detach()
+	if tags(root) != "first,b,last" {
+		t.Fatalf("detach left %s", tags(root))
+	}
+	checkLinks(t, root)
+
+	defer func() {
+		if recover() != ErrHierarchy {
+			t.Fatal("moving an element inside itself should panic")
+		}
+	}()
+	b.AddChild(root)
+}
+
+func TestClone(t *testing.T) {
+	page := parseTestPage()
+	main := page.ById("main")
+	clone := main.Clone()
+
+	if clone.GetParent() != nil {
+		t.Fatal("a clone should have no parent")
+	}
+	form, ok := clone.ById("login").(*Form)
+	if !ok {
+		t.Fatal("clone lost the Form type")
+	}
+	input := form.GetInputs()[0]
+	if _, ok := input.(*Input); !ok || input.GetParent() != form {
+		t.Fatal("clone lost the Input type or its parent link")
+	}
+	checkLinks(t, clone)
+
+	clone.ByClass("intro").SetContent("changed")
+	if main.ByClass("intro").GetContent() != "first" {
+		t.Fatal("changing the clone changed the original")
+	}
+}
+
+func TestTraversal(t *testing.T) {
+	page := parseTestPage()
+	main := page.ById("main")
+
+	order := []string{}
+	for e := range main.Descendants() {
+		order = append(order, e.GetTagName())
+	}
+	if strings.Join(order, ",") != "p,p,form,input,input" {
+		t.Fatalf("descendants not in document order: %v", order)
+	}
+
+	visited := []string{}
+	Walk(page.root, func(e Element) WalkAction {
+		visited = append(visited, e.GetTagName())
+		if e.GetTagName() == "div" {
+			return WALK_SKIP_CHILDREN
+		}
+		if e.GetTagName() == "li" {
+			return WALK_STOP
+		}
+		return WALK_CONTINUE
+	})
+	if strings.Join(visited, ",") != "html,head,title,body,div,ul,li" {
+		t.Fatalf("unexpected walk %v", visited)
+	}
+
+	input := page.ById("login").ByTag("input")
+	v, _ := NewTagValidator("div")
+	if input.Closest(v) != main {
+		t.Fatal("closest div should be #main")
+	}
+
+	ancestors := []string{}
+	for a := range input.Ancestors() {
+		ancestors = append(ancestors, a.GetTagName())
+	}
+	if strings.Join(ancestors, ",") != "form,div,body,html" {
+		t.Fatalf("unexpected ancestors %v", ancestors)
+	}
+
+	items := page.ById("list").GetChildren()
+	if items[1].Index() != 1 || items[1].NextSibling() != items[2] || items[1].PrevSibling() != items[0] {
+		t.Fatal("sibling navigation is wrong")
+	}
+}
+
+func TestSiblingsOfCustomTags(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body><ul>
+<li id="a">one</li> <my-item id="b">two</my-item> <li id="c">three</li>
+</ul></body></html>`))
+	a, b, c := page.ById("a"), page.ById("b"), page.ById("c")
+	if a.NextSibling() != b || c.PrevSibling() != b {
+		t.Errorf("expected custom elements to be siblings")
+	}
+	if b.Index() != 1 || c.Index() != 2 {
+		t.Errorf("unexpected indexes %d %d", b.Index(), c.Index())
+	}
+}
+
+func TestComposedValidators(t *testing.T) {
+	page := parseTestPage()
+
+	p, _ := NewTagValidator("p")
+	li, _ := NewTagValidator("li")
+	intro, _ := NewAttributeValidator("class", "intro")
+
+	if found := BFS(page.root, And(p, Not(intro))); len(found) != 1 || found[0].GetContent() != "second" {
+		t.Fatalf("And/Not found %v", found)
+	}
+	if found := BFS(page.root, Or(p, li)); len(found) != 5 {
+		t.Fatalf("Or found %d elements", len(found))
+	}
+	if found := BFS(page.root, HasAttribute("action")); len(found) != 1 {
+		t.Fatalf("HasAttribute found %d elements", len(found))
+	}
+
+	div, _ := NewTagValidator("div")
+	if found := BFSFirst(page.root, And(div, TextContains("second"))); found != page.ById("main") {
+		t.Fatal("TextContains should look at the text below an element")
+	}
+	matches, err := TextMatches(`^[abc]$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := BFS(page.root, And(li, matches)); len(found) != 3 {
+		t.Fatalf("TextMatches found %d elements", len(found))
+	}
+	if found := BFS(page.root, Func(func(e Element) bool { return e.GetAttribute("name") == "pass" })); len(found) != 1 {
+		t.Fatal("Func validator didn't match")
+	}
+
+	body := page.root.ByTag("body")
+	if found := BFSDepth(body, p, 1); len(found) != 0 {
+		t.Fatal("paragraphs are two levels below body")
+	}
+	if found := BFSDepth(body, p, 2); len(found) != 2 {
+		t.Fatalf("expected 2 paragraphs within depth 2, got %d", len(found))
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body>
+		<a id="a.b" class="price.now col-md-6" href="/search?q=1">1</a>
+		<a id="axb" class="price" href="/search?q=2">2</a>
+		<a id="post-12" href="/files/report.pdf">3</a>
+		<a id="42" title="<nil>">4</a>
+	</body></html>`))
+
+	if found := page.AllById("a.b"); len(found) != 1 || found[0].GetContent() != "1" {
+		t.Fatalf("plain strings should match exactly, got %v", found)
+	}
+	if found := page.AllById(Legacy("a.b")); len(found) != 2 {
+		t.Fatalf("legacy matching should treat a.b as a regex, got %v", found)
+	}
+	if page.ByAttribute("href", "/search?q=1") == nil {
+		t.Fatal("query strings should match literally")
+	}
+	if found := page.AllByAttribute("href", Prefix("/search")); len(found) != 2 {
+		t.Fatalf("prefix found %v", found)
+	}
+	if found := page.AllByAttribute("href", Suffix(".pdf")); len(found) != 1 {
+		t.Fatalf("suffix found %v", found)
+	}
+	if found := page.AllByAttribute("href", Contains("q=")); len(found) != 2 {
+		t.Fatalf("contains found %v", found)
+	}
+	if found := page.AllById(Regex(`^post-[0-9]+$`)); len(found) != 1 {
+		t.Fatalf("regex found %v", found)
+	}
+	if found := page.AllByAttribute("href", Glob("/files/*.pdf")); len(found) != 1 {
+		t.Fatalf("glob found %v", found)
+	}
+	if found := page.AllByAttribute("href", Glob("/search?q=[!1]")); len(found) != 1 || found[0].GetContent() != "2" {
+		t.Fatalf("glob class found %v", found)
+	}
+	if found := page.AllByClass("price.now"); len(found) != 1 {
+		t.Fatalf("class with regex characters found %v", found)
+	}
+	if found := page.AllByClass("price"); len(found) != 1 || found[0].GetContent() != "2" {
+		t.Fatalf("class should match whole class names, got %v", found)
+	}
+	if !Glob("a[]b").Match("a[]b") || !Glob("[]").Match("[]") || Glob("[z-a]").Match("z") {
+		t.Errorf("expected empty and invalid sets to be taken literally")
+	}
+	if page.ById(42) != nil || page.ByAttribute("title", nil) != nil {
+		t.Errorf("expected nil and unsupported types to match nothing")
+	}
+	if page.ByAttribute("href", Contains("q=")) == nil || !HasAttribute("href").Validate(page.ByAttribute("href", Contains("q="))) {
+		t.Errorf("expected HasAttribute to find href")
+	}
+}
+
+func TestClasses(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body>
+		<div id="a" class="col col-md-6 active">1</div>
+		<div id="b" class="col-md-6">2</div>
+		<div id="c" class="  col   active ">3</div>
+	</body></html>`))
+
+	if found := page.AllByClass("col"); len(found) != 2 {
+		t.Fatalf("col should not match col-md-6, got %v", found)
+	}
+	if found := page.AllByClass("col-md-6"); len(found) != 2 {
+		t.Fatalf("expected 2 col-md-6 elements, got %v", found)
+	}
+	if found := page.AllByClasses("col", "active"); len(found) != 2 {
+		t.Fatalf("expected 2 elements with col and active, got %v", found)
+	}
+	if found := page.AllByClass("active col-md-6"); len(found) != 1 || found[0].GetAttribute("id") != "a" {
+		t.Fatalf("space separated classes should all be required, got %v", found)
+	}
+
+	b := page.ById("b")
+	b.AddClass("active")
+	b.AddClass("active")
+	if b.GetAttribute("class") != "col-md-6 active" || !b.HasClass("active") {
+		t.Fatalf("AddClass gave %q", b.GetAttribute("class"))
+	}
+	b.RemoveClass("col-md-6")
+	if b.GetAttribute("class") != "active" || b.HasClass("col-md-6") {
+		t.Fatalf("RemoveClass gave %q", b.GetAttribute("class"))
+	}
+	if b.ToggleClass("active") || b.HasClass("active") {
+		t.Fatal("ToggleClass should have removed active")
+	}
+	if !b.ToggleClass("active") || !b.HasClass("active") {
+		t.Fatal("ToggleClass should have added active")
+	}
+}
+
+func TestTextAndImpliedEnds(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body>
+<p id="a">Hello <b>big</b> world<p id="b">next</p>
+<ul><li>one<li>two</ul>
+<table id="t"><tr><td>1<td>2<tr><td>3</table>
+<div id="d">x</span>y</div>
+<script>if (a < b) {}</script>
+</body></html>`))
+
+	if text := Text(page.ById("a")); text != "Hello big world" {
+		t.Errorf("expected text in document order, got %q", text)
+	}
+	if content := page.ById("a").GetContent(); content != "Hello  world" {
+		t.Errorf("expected content without the child's text, got %q", content)
+	}
+	if tail := page.ById("a").ByTag("b").GetTail(); tail != " world" {
+		t.Errorf("expected the text after b in its tail, got %q", tail)
+	}
+	if page.ById("b").GetParent().GetTagName() != "body" {
+		t.Errorf("expected <p> to close the open <p>")
+	}
+	if items := page.root.AllByTag("li"); len(items) != 2 || Text(items[1]) != "two" {
+		t.Errorf("expected two separate list items, got %d", len(items))
+	}
+	if rows := page.ById("t").AllByTag("tr"); len(rows) != 2 || len(rows[0].GetChildren()) != 2 {
+		t.Errorf("expected cells and rows to close each other, got %d rows", len(rows))
+	}
+	if content := page.ById("d").GetContent(); content != "xy" {
+		t.Errorf("expected a stray end tag to be ignored, got %q", content)
+	}
+	if text := Text(page.root.ByTag("script")); text != "if (a < b) {}" {
+		t.Errorf("expected raw script text, got %q", text)
+	}
+
+	// Removing an element keeps the text around it
+	a := page.ById("a")
+	a.RemoveChild(a.ByTag("b"))
+	if text := Text(a); text != "Hello  world" {
+		t.Errorf("expected surrounding text kept, got %q", text)
+	}
+}
+
+func TestCustomTags(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body><my-widget id="w"><p>inside</p></my-widget><p>after</p></body></html>`))
+	widget := page.ById("w")
+	if widget == nil || widget.GetTagName() != "my-widget" || widget.GetKind() != ELEM_NORMAL {
+		t.Fatalf("expected custom tag to keep its name, got %v", widget)
+	}
+	if len(widget.AllByTag("p")) != 1 {
+		t.Errorf("expected the widget to hold one paragraph")
+	}
+}
+
+func TestTable(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body><table>
+<caption> Prices </caption>
+<thead><tr><th rowspan="2">Item<th colspan="2">Cost</tr>
+<tr><th>Net<th>Gross</tr></thead>
+<tbody>
+<tr><td rowspan="2">Tea<td>1<td>1.2
+<tr><td>2<td>2.4
+<tr><td colspan="3"><table><tr><td>nested</td></tr></table></td></tr>
+</tbody></table></body></html>`))
+
+	table, err := NewTable(page.root.ByTag("table"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Caption != "Prices" {
+		t.Errorf("unexpected caption %q", table.Caption)
+	}
+	if got := strings.Join(table.Headers, "|"); got != "Item|Cost Net|Cost Gross" {
+		t.Errorf("unexpected headers %q", got)
+	}
+	want := [][]string{{"Tea", "1", "1.2"}, {"Tea", "2", "2.4"}, {"nested", "nested", "nested"}}
+	if len(table.Rows) != len(want) {
+		t.Fatalf("expected %d rows, got %v", len(want), table.Rows)
+	}
+	for i := range want {
+		if strings.Join(table.Rows[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d: expected %v, got %v", i, want[i], table.Rows[i])
+		}
+	}
+	if m := table.Maps()[1]; m["Cost Gross"] != "2.4" {
+		t.Errorf("unexpected map %v", m)
+	}
+
+	var csv strings.Builder
+	if err := table.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(csv.String(), "Item,Cost Net,Cost Gross\nTea,1,1.2\n") {
+		t.Errorf("unexpected csv %q", csv.String())
+	}
+	if len(page.Tables()) != 1 {
+		t.Errorf("expected nested tables to be left out of Tables")
+	}
+	if _, err := NewTable(page.root.ByTag("caption")); err != ErrNotTable {
+		t.Errorf("expected ErrNotTable, got %v", err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><body>
+<div class="story" id="s1"><a class="title" href="/one">First <b>story</b></a>
+<span class="score">12</span><time datetime="2024-01-02T03:04:05Z"></time></div>
+<div class="story" id="s2"><a class="title" href="/two">Second</a><span class="score"></span></div>
+<ul id="tags"><li>go</li><li>html</li></ul>
+</body></html>`))
+
+	type story struct {
+		Title  string     `grawl:"css=a.title;required"`
+		Link   *url.URL   `grawl:"css=div > a[href^='/'];attr=href"`
+		Score  int        `grawl:"css=.score"`
+		Posted *time.Time `grawl:"css=time;attr=datetime"`
+		Root   Element    `grawl:""`
+	}
+	var front struct {
+		Stories []story  `grawl:"css=div.story"`
+		Tags    []string `grawl:"css=#tags li"`
+		Ignored string
+	}
+	if err := page.Unmarshal(&front); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(front.Stories) != 2 {
+		t.Fatalf("expected 2 stories, got %d", len(front.Stories))
+	}
+	first, second := front.Stories[0], front.Stories[1]
+	if first.Title != "First story" || first.Link.Path != "/one" || first.Score != 12 {
+		t.Errorf("unexpected first story %+v", first)
+	}
+	if first.Posted == nil || first.Posted.Year() != 2024 || second.Posted != nil {
+		t.Errorf("unexpected times %v %v", first.Posted, second.Posted)
+	}
+	if second.Score != 0 || second.Root.GetAttribute("id") != "s2" {
+		t.Errorf("unexpected second story %+v", second)
+	}
+	if strings.Join(front.Tags, ",") != "go,html" {
+		t.Errorf("unexpected tags %v", front.Tags)
+	}
+
+	var missing struct {
+		Price float64 `grawl:"css=.price;required"`
+	}
+	if err := page.Unmarshal(&missing); !errors.Is(err, ErrRequired) {
+		t.Errorf("expected ErrRequired, got %v", err)
+	}
+	var bad struct {
+		Score int `grawl:"css=a.title"`
+	}
+	if err := page.Unmarshal(&bad); err == nil || !strings.Contains(err.Error(), ".Score") {
+		t.Errorf("expected a conversion error naming the field, got %v", err)
+	}
+
+	for _, css := range []string{"div:first-child", "a + b", "a ~ b", "a>", "li.x:hover"} {
+		if _, err := compileSelector(css); err == nil {
+			t.Errorf("expected a syntax error for %q", css)
+		}
+	}
+	var quoted struct {
+		Link string `grawl:"css=a[href='/one;two'], a[href=\"/two\"];attr=href"`
+	}
+	if err := page.Unmarshal(&quoted); err != nil || quoted.Link != "/two" {
+		t.Errorf("expected ; inside quotes to stay in the selector, got %q %v", quoted.Link, err)
+	}
+	plans, _ := planFields(reflect.TypeOf(story{}), "story")
+	again, _ := planFields(reflect.TypeOf(story{}), "story")
+	if plans[0].sel == nil || plans[0].sel != again[0].sel {
+		t.Errorf("expected selectors to be compiled once per type")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html lang="en-GB"><head>
+<title> Grawl  news </title>
+<meta name="Description" content="All the news">
+<meta name="keywords" content="go, scraping,,html">
+<meta name="author" content="someone">
+<meta property="og:title" content="Grawl">
+<meta property="og:image" content="/img/logo.png">
+<meta property="og:image" content="/img/second.png">
+<meta name="twitter:card" content="summary">
+<link rel="canonical" href="/news">
+<link rel="alternate" hreflang="de" href="/de/news">
+<link rel="alternate" type="application/rss+xml" title="Feed" href="feed.xml">
+<link rel="alternate" type="application/json" href="/wp-json/wp/v2/posts/1">
+<link rel="alternate" type="Application/Atom+xml; charset=utf-8" href="/atom.xml">
+<link rel="shortcut icon" href="/favicon.png">
+</head><body><svg><title>icon</title></svg></body></html>`))
+	page.SetUrl("https://example.com/news/today")
+
+	meta := page.Metadata()
+	if meta.Title != "Grawl news" || meta.Description != "All the news" || meta.Language != "en-GB" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if strings.Join(meta.Keywords, "|") != "go|scraping|html" || meta.Meta["author"] != "someone" {
+		t.Errorf("unexpected keywords or meta %v %v", meta.Keywords, meta.Meta)
+	}
+	if meta.Canonical != "https://example.com/news" || meta.Favicon != "https://example.com/favicon.png" {
+		t.Errorf("unexpected canonical or favicon %q %q", meta.Canonical, meta.Favicon)
+	}
+	if meta.OpenGraph["og:image"] != "https://example.com/img/logo.png" || meta.Twitter["twitter:card"] != "summary" {
+		t.Errorf("unexpected properties %v %v", meta.OpenGraph, meta.Twitter)
+	}
+	if len(meta.Alternates) != 1 || meta.Alternates[0].Lang != "de" {
+		t.Errorf("unexpected alternates %v", meta.Alternates)
+	}
+	if len(meta.Feeds) != 2 || meta.Feeds[0].Href != "https://example.com/news/feed.xml" || meta.Feeds[1].Type != "application/atom+xml" {
+		t.Errorf("unexpected feeds %v", meta.Feeds)
+	}
+}
+
+func TestStructuredData(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><head>
+<script type="application/ld+json">{"@type": "Product", "name": "Tea &amp; cake", "note": "a < b"}</script>
+<script type="application/ld+json">[{"@type": "A"}, {"@type": "B"}]</script>
+<script type="application/ld+json">{broken</script>
+</head><body>
+<div itemscope itemtype="https://schema.org/Product" itemref="extra">
+	<span itemprop="name">Tea</span>
+	<img itemprop="image" src="/tea.png">
+	<div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+		<meta itemprop="price" content="2.50"><span itemprop="name">not the product name</span>
+	</div>
+</div>
+<p id="extra" itemprop="description">Loose leaf</p>
+<div vocab="https://schema.org/" typeof="Person">
+	<span property="name">Ada</span>
+	<a property="url" href="/ada">home</a>
+	<div property="address" typeof="PostalAddress"><span property="addressLocality">London</span></div>
+</div>
+</body></html>`))
+	page.SetUrl("https://shop.example/")
+
+	data := page.StructuredData()
+	if len(data.JSONLD) != 3 || len(data.Errors) != 1 {
+		t.Fatalf("expected 3 JSON-LD objects and 1 error, got %v %v", data.JSONLD, data.Errors)
+	}
+	product := data.JSONLD[0].(map[string]interface{})
+	if product["name"] != "Tea &amp; cake" || product["note"] != "a < b" {
+		t.Errorf("expected script text untouched, got %v", product)
+	}
+
+	if len(data.Microdata) != 1 {
+		t.Fatalf("expected 1 top level item, got %d", len(data.Microdata))
+	}
+	item := data.Microdata[0]
+	if item.Type[0] != "https://schema.org/Product" || item.Get("name") != "Tea" || len(item.Properties["name"]) != 1 {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if item.Get("image") != "https://shop.example/tea.png" || item.Get("description") != "Loose leaf" {
+		t.Errorf("unexpected item properties %v", item.Properties)
+	}
+	if offer := item.GetItem("offers"); offer == nil || offer.Get("price") != "2.50" {
+		t.Errorf("unexpected offer %+v", offer)
+	}
+
+	if len(data.RDFa) != 1 {
+		t.Fatalf("expected 1 RDFa item, got %d", len(data.RDFa))
+	}
+	person := data.RDFa[0]
+	if person.Type[0] != "https://schema.org/Person" || person.Get("name") != "Ada" || person.Get("url") != "https://shop.example/ada" {
+		t.Errorf("unexpected person %+v", person)
+	}
+	if address := person.GetItem("address"); address == nil || address.Get("addressLocality") != "London" {
+		t.Errorf("unexpected address %+v", address)
+	}
+}
+
+func TestMainContent(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><head>
+<title>Grawl reaches version two today | Example News</title>
+<meta name="author" content="Ada Lovelace">
+<meta property="article:published_time" content="2024-03-01T09:00:00Z">
+</head><body>
+<nav><a href="/">Home</a> <a href="/news">News</a></nav>
+<div id="sidebar"><ul><li><a href="/a">Other story</a></li><li><a href="/b">Another story</a></li></ul></div>
+<div class="article-body">
+	<p>Grawl, the scraping library, reached version two today, bringing a new parser, tables and structured data.</p>
+	<p>The release took a year of work, with dozens of contributors, and many, many bug reports from users.</p>
+	<div class="share-tools"><a href="/share">Share this</a></div>
+	<p>Upgrading is easy, most code keeps working, though some queries now match exactly.</p>
+</div>
+<div class="comments"><p>First! This is a comment, with commas, that should not be part of the article.</p></div>
+<footer>Copyright</footer>
+</body></html>`))
+
+	article, err := page.MainContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Grawl reaches version two today" || article.Byline != "Ada Lovelace" {
+		t.Errorf("unexpected title or byline %q %q", article.Title, article.Byline)
+	}
+	if article.Published.Year() != 2024 {
+		t.Errorf("unexpected publish date %v", article.Published)
+	}
+	if !strings.HasPrefix(article.Text, "Grawl, the scraping library") || !strings.Contains(article.Text, "\n\nUpgrading is easy") {
+		t.Errorf("unexpected text %q", article.Text)
+	}
+	for _, clutter := range []string{"Home", "Other story", "Share this", "First!", "Copyright"} {
+		if strings.Contains(article.Html, clutter) {
+			t.Errorf("expected %q to be removed from %s", clutter, article.Html)
+		}
+	}
+	if page.ByClass("share-tools") == nil {
+		t.Errorf("expected the page itself to be left alone")
+	}
+
+	// Text between gathered siblings comes along with them
+	loose := ParseBody(strings.NewReader(`<html><body><div><div id="top"><p>Top</p></div>Loose text.<p>A short one.</p>After.</div></body></html>`))
+	gathered := gatherSiblings(loose.ById("top"), 100, map[Element]float64{})
+	if got := Text(gathered); got != "TopLoose text.A short one.After." {
+		t.Errorf("expected the text between siblings to be kept, got %q", got)
+	}
+
+	empty := ParseBody(strings.NewReader(`<html><body><nav><a href="/">Home</a></nav></body></html>`))
+	if _, err := empty.MainContent(); err != ErrNoContent {
+		t.Errorf("expected ErrNoContent, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	page := ParseBody(strings.NewReader(`<html><head><title>x</title></head><body><div id="doc">
+<h1>Release <em>notes</em></h1>
+<p>Grawl is a <b>scraping</b> library, see <a href="/docs?a=1">the docs</a> or the
+<img src="/logo.png" alt="logo">. Use *stars* freely.<br>New line.</p>
+<ul><li>one</li><li>two<ol start="3"><li>three</li></ol></li></ul>
+<pre><code class="language-go">if a &lt; b {
+	return
+}</code></pre>
+<blockquote><p>Quoted</p></blockquote>
+<table><tr><th>Name</th><th>Qty</th></tr><tr><td>Tea</td><td>2</td></tr></table>
+<script>ignored()</script>
+</div></body></html>`))
+	doc := page.ById("doc")
+
+	wantMarkdown := "# Release *notes*\n\n" +
+		"Grawl is a **scraping** library, see [the docs](/docs?a=1) or the\n" +
+		"![logo](/logo.png). Use \\*stars\\* freely.\\\n" +
+		"New line.\n\n" +
+		"- one\n- two\n\n  3. three\n\n" +
+		"```go\nif a < b {\n\treturn\n}\n```\n\n" +
+		"> Quoted\n\n" +
+		"| Name | Qty |\n| ---- | --- |\n| Tea  | 2   |"
+	if got := Markdown(doc, 70); got != wantMarkdown {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", got, wantMarkdown)
+	}
+
+	text := doc.ToText()
+	for _, want := range []string{"Release notes\n\n", "see the docs or the logo. Use *stars* freely.\nNew line.", "Name  Qty\n----  ---\nTea   2"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in text:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "ignored") {
+		t.Errorf("expected scripts to be left out")
+	}
+	if wrapped := wrap("one two three four", 9); strings.Join(wrapped, "|") != "one two|three|four" {
+		t.Errorf("unexpected wrapping %v", wrapped)
+	}
+}
+
+func TestXmlMode(t *testing.T) {
+	const soap = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:m="urn:prices">
+<soap:Body>
+	<m:GetPriceResponse>
+		<m:Price currency="EUR">1.90</m:Price>
+		<m:Note><![CDATA[<b>fresh</b> & cheap]]></m:Note>
+		<m:Item/>
+		<title>Tea</title>
+		<br>text</br>
+	</m:GetPriceResponse>
+</soap:Body>
+</soap:Envelope>`
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {"application/soap+xml; charset=utf-8"}},
+		Body:   io.NopCloser(strings.NewReader(soap)),
+	}
+	page := ParseResp(resp)
+
+	if page.root.GetTagName() != "soap:Envelope" {
+		t.Fatalf("expected soap:Envelope root, got %q", page.root.GetTagName())
+	}
+	price := page.ByTag("m:Price")
+	if price == nil || price.GetContent() != "1.90" || price.GetAttribute("currency") != "EUR" {
+		t.Fatalf("unexpected price %v", price)
+	}
+	if page.ByAttribute("currency", "EUR") != price {
+		t.Errorf("expected ByAttribute to find the price")
+	}
+	if note := page.ByTag("m:Note"); note == nil || note.GetContent() != "<b>fresh</b> & cheap" {
+		t.Errorf("expected CDATA kept as text, got %v", note)
+	}
+	// Html void and raw tags mean nothing in xml
+	if br := page.ByTag("br"); br == nil || br.GetKind() != ELEM_NORMAL || br.GetContent() != "text" {
+		t.Errorf("expected br to hold its text, got %v", br)
+	}
+	if item := page.ByTag("m:Item"); item == nil || item.GetParent().GetTagName() != "m:GetPriceResponse" {
+		t.Errorf("expected self closed element to be closed")
+	}
+
+	body := page.ByNamespace("http://schemas.xmlsoap.org/soap/envelope/", "Body")
+	if body == nil || body.GetTagName() != "soap:Body" || LocalName(body) != "Body" {
+		t.Errorf("expected to find the body by namespace, got %v", body)
+	}
+	if len(page.AllByNamespace("urn:prices", "")) != 4 {
+		t.Errorf("expected 4 elements in urn:prices, got %d", len(page.AllByNamespace("urn:prices", "")))
+	}
+	if ns := NamespaceOf(page.ByTag("title")); ns != "" {
+		t.Errorf("expected no namespace for title, got %q", ns)
+	}
+
+	// The same document served as html is tokenized as html
+	html := ParseResp(&http.Response{
+		Header: http.Header{"Content-Type": {"text/html"}},
+		Body:   io.NopCloser(strings.NewReader(soap)),
+	})
+	if html.ByTag("m:Price") != nil || html.ByTag("m:price") == nil {
+		t.Errorf("expected html mode to lower case tag names")
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	cases := map[string]string{
+		`<script>window.location = "/next";</script>`:                         "https://example.com/next",
+		`<script>if (ok) { location.replace('https://other.org/') }</script>`: "",
+		`<script>document.location.href='next?a=1'</script>`:                  "https://example.com/dir/next?a=1",
+		`<script> top.location.replace("/framed"); </script>`:                 "https://example.com/framed",
+		`<body onload="location.assign('/loaded')">`:                          "https://example.com/loaded",
+		`<body onload="if (x) location.assign('/loaded')">`:                   "",
+		`<a onclick="location = '/clicked'">go</a>`:                           "",
+		`<script>// location = "/commented"</script>`:                         "",
+		`<script>if (location.href == "/x") { track() }</script>`:             "",
+		`<script src="/app.js">location = "/ignored"</script>`:                "",
+		`<script>var url = "/next"; location = url</script>`:                  "",
+		`<script>track(); location = "/after"; setup()</script>`:              "",
+	}
+	for html, want := range cases {
+		page := ParseBody(strings.NewReader("<html>" + html + "</html>"))
+		page.SetUrl("https://example.com/dir/page")
+		if got := page.JsRedirect(); got != want {
+			t.Errorf("%s: expected %q, got %q", html, want, got)
+		}
+	}
 
+	page := ParseBody(strings.NewReader(`<html><head>
+<meta http-equiv="refresh" content="0;url=/meta">
+<link rel="canonical" href="/article">
+</head><body><script>location.href = "/js"</script></body></html>`))
+	page.SetUrl("https://example.com/amp/article")
+	if target, kind := page.ClientRedirect(); target != "https://example.com/meta" || kind != REDIRECT_META_REFRESH {
+		t.Errorf("expected the meta refresh first, got %q %v", target, kind)
+	}
+	if page.Canonical() != "https://example.com/article" {
+		t.Errorf("unexpected canonical %q", page.Canonical())
+	}
 }
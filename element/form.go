@@ -11,6 +11,7 @@ type Input struct {
 
 func NewInput() *Input {
 	e := Input{*NewBaseElement()}
+	e.self = &e
 	return &e
 }
 
@@ -24,6 +25,7 @@ type Form struct {
 
 func NewForm() *Form {
 	form := Form{*NewBaseElement(), &url.Values{}}
+	form.self = &form
 	return &form
 }
 
@@ -46,12 +48,7 @@ func (e *Form) GetSelects() []Element {
 }
 
 func (e *Form) GetFields() []Element {
-	val, err := NewTagValidator("select|input")
-	if err == nil {
-		return BFS(e, *val)
-	}
-
-	return []Element{}
+	return BFS(e, MatchTag(Regex("^(select|input)$")))
 }
 
 func (e *Form) Name() string {
@@ -0,0 +1,157 @@
+package element
+
+import (
+	"github.com/tlowry/grawl/util"
+	"regexp"
+	"strings"
+)
+
+/*
+	A Matcher decides whether a tag name or attribute value is the one
+	being searched for. Every query method accepts a Matcher, a plain
+	string (matched exactly) or a compiled *regexp.Regexp.
+	Example: find the links into the search pages
+	links := page.AllByAttribute("href", element.Prefix("/search?"))
+*/
+type Matcher interface {
+	Match(s string) bool
+}
+
+type exactMatcher string
+type prefixMatcher string
+type suffixMatcher string
+type containsMatcher string
+
+type regexMatcher struct {
+	regex *regexp.Regexp
+}
+
+// Match the whole string exactly, plain strings given to queries do this
+func Exact(text string) Matcher {
+	return exactMatcher(text)
+}
+
+// Match strings starting with prefix
+func Prefix(prefix string) Matcher {
+	return prefixMatcher(prefix)
+}
+
+// Match strings ending with suffix
+func Suffix(suffix string) Matcher {
+	return suffixMatcher(suffix)
+}
+
+// Match strings containing text anywhere
+func Contains(text string) Matcher {
+	return containsMatcher(text)
+}
+
+/*
+	Match a go regular expression, like regexp.MatchString it matches
+	anywhere in the string so use ^ and $ to match the whole value.
+	Panics if the pattern doesn't compile.
+	Example: element.Regex(`^post-[0-9]+$`)
+*/
+func Regex(pattern string) Matcher {
+	return regexMatcher{regexp.MustCompile(pattern)}
+}
+
+/*
+	Match a shell style pattern against the whole string,
+	* matches any run of characters (including /), ? matches one
+	and [abc] matches one of a set.
+	Example: element.Glob("*.pdf")
+*/
+func Glob(pattern string) Matcher {
+	var re strings.Builder
+	re.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch ch := runes[i]; ch {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			end := strings.IndexRune(string(runes[i+1:]), ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := []rune(string(runes[i+1:])[:end])
+			if len(class) > 0 && class[0] == '!' {
+				class[0] = '^'
+			}
+			set := "[" + strings.ReplaceAll(string(class), `\`, `\\`) + "]"
+			// An empty or backwards set such as [] or [z-a] is taken literally
+			if _, err := regexp.Compile(set); err != nil {
+				re.WriteString(`\[`)
+				continue
+			}
+			re.WriteString(set)
+			i += len(class) + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	re.WriteString("$")
+	return regexMatcher{regexp.MustCompile(re.String())}
+}
+
+/*
+	The old query behaviour, text is treated as a regular expression
+	if it contains any regex characters and matched exactly otherwise.
+	Only use this for queries written against that behaviour.
+*/
+func Legacy(text string) Matcher {
+	if util.ContainsRegex(text) {
+		return Regex(text)
+	}
+	return exactMatcher(text)
+}
+
+// Matches nothing, for values a query can't use
+type noMatcher struct{}
+
+func (m exactMatcher) Match(s string) bool {
+	return s == string(m)
+}
+
+func (m prefixMatcher) Match(s string) bool {
+	return strings.HasPrefix(s, string(m))
+}
+
+func (m suffixMatcher) Match(s string) bool {
+	return strings.HasSuffix(s, string(m))
+}
+
+func (m containsMatcher) Match(s string) bool {
+	return strings.Contains(s, string(m))
+}
+
+func (m regexMatcher) Match(s string) bool {
+	return m.regex.MatchString(s)
+}
+
+func (m noMatcher) Match(s string) bool {
+	return false
+}
+
+/*
+	Turn a value passed to a query method into a Matcher. Only a Matcher,
+	a string or a *regexp.Regexp are accepted, anything else, nil
+	included, matches nothing.
+*/
+func toMatcher(value interface{}) Matcher {
+	switch v := value.(type) {
+	case Matcher:
+		return v
+	case string:
+		return exactMatcher(v)
+	case *regexp.Regexp:
+		if v != nil {
+			return regexMatcher{v}
+		}
+	}
+	return noMatcher{}
+}
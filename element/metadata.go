@@ -0,0 +1,207 @@
+package element
+
+import (
+	"mime"
+	"strings"
+)
+
+/*
+	A <link> from the page head, Href is absolute and Type is the media
+	type without parameters such as charset
+*/
+type Link struct {
+	Href  string
+	Type  string
+	Title string
+	// The hreflang of alternate language versions
+	Lang string
+}
+
+/*
+	The metadata a page describes itself with. All urls are resolved
+	against the pages url. OpenGraph and Twitter hold the og: and twitter:
+	properties by their full name, the first value wins when one repeats.
+*/
+type Metadata struct {
+	Title       string
+	Description string
+	Keywords    []string
+	Canonical   string
+	Language    string
+	Favicon     string
+	// Alternate language versions of the page
+	Alternates []Link
+	// RSS, Atom and JSON feeds
+	Feeds     []Link
+	OpenGraph map[string]string
+	Twitter   map[string]string
+	// Every other <meta name="..."> by lower case name, such as "author" or "robots"
+	Meta map[string]string
+}
+
+// OpenGraph and Twitter properties holding urls
+var urlProperties = map[string]bool{
+	"og:url":              true,
+	"og:image":            true,
+	"og:image:url":        true,
+	"og:image:secure_url": true,
+	"og:video":            true,
+	"og:video:url":        true,
+	"og:audio":            true,
+	"twitter:image":       true,
+	"twitter:image:src":   true,
+	"twitter:player":      true,
+}
+
+// Plain application/json alternates are usually APIs (oEmbed, wp-json), not JSON Feeds
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+/*
+	Collect the pages title, description, canonical url, feeds,
+	OpenGraph and Twitter card properties and so on.
+	Example:
+	meta := page.Metadata()
+	log.Println(meta.Title, meta.OpenGraph["og:image"])
+*/
+func (p *Page) Metadata() *Metadata {
+	m := Metadata{
+		Keywords:   []string{},
+		Alternates: []Link{},
+		Feeds:      []Link{},
+		OpenGraph:  map[string]string{},
+		Twitter:    map[string]string{},
+		Meta:       map[string]string{},
+	}
+	if p.root == nil {
+		return &m
+	}
+
+	favicon := ""
+	contentLanguage := ""
+	Walk(p.root, func(e Element) WalkAction {
+		switch e.GetTagName() {
+		case "html":
+			if m.Language == "" {
+				m.Language = strings.TrimSpace(e.GetAttribute("lang"))
+			}
+		case "svg":
+			// An svg has a <title> of its own
+			return WALK_SKIP_CHILDREN
+		case "title":
+			if m.Title == "" {
+				m.Title = CleanText(e)
+			}
+		case "meta":
+			p.addMeta(&m, e, &contentLanguage)
+		case "link":
+			p.addLink(&m, e, &favicon)
+		}
+		return WALK_CONTINUE
+	})
+
+	if m.Language == "" {
+		m.Language = contentLanguage
+	}
+	if m.Favicon == "" {
+		m.Favicon = favicon
+	}
+	return &m
+}
+
+func (p *Page) addMeta(m *Metadata, e Element, contentLanguage *string) {
+	content := strings.TrimSpace(e.GetAttribute("content"))
+
+	if strings.EqualFold(e.GetAttribute("http-equiv"), "content-language") && *contentLanguage == "" {
+		*contentLanguage = content
+		return
+	}
+
+	// OpenGraph uses property=, though plenty of sites use name= for both
+	key := strings.ToLower(strings.TrimSpace(e.GetAttribute("property")))
+	if key == "" {
+		key = strings.ToLower(strings.TrimSpace(e.GetAttribute("name")))
+	}
+	if key == "" || content == "" {
+		return
+	}
+	if urlProperties[key] {
+		content = p.resolve(content)
+	}
+
+	switch {
+	case strings.HasPrefix(key, "og:"):
+		setFirst(m.OpenGraph, key, content)
+	case strings.HasPrefix(key, "twitter:"):
+		setFirst(m.Twitter, key, content)
+	case key == "description":
+		if m.Description == "" {
+			m.Description = content
+		}
+	case key == "keywords":
+		if len(m.Keywords) == 0 {
+			for _, keyword := range strings.Split(content, ",") {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					m.Keywords = append(m.Keywords, keyword)
+				}
+			}
+		}
+	default:
+		setFirst(m.Meta, key, content)
+	}
+}
+
+// The lower case media type of a type attribute, "application/rss+xml; charset=utf-8" gives "application/rss+xml"
+func mediaType(text string) string {
+	if media, _, err := mime.ParseMediaType(text); err == nil {
+		return media
+	}
+	media, _, _ := strings.Cut(text, ";")
+	return strings.ToLower(strings.TrimSpace(media))
+}
+
+func (p *Page) addLink(m *Metadata, e Element, favicon *string) {
+	href := strings.TrimSpace(e.GetAttribute("href"))
+	if href == "" {
+		return
+	}
+	link := Link{
+		Href:  p.resolve(href),
+		Type:  mediaType(e.GetAttribute("type")),
+		Title: strings.TrimSpace(e.GetAttribute("title")),
+		Lang:  strings.TrimSpace(e.GetAttribute("hreflang")),
+	}
+
+	for _, rel := range strings.Fields(strings.ToLower(e.GetAttribute("rel"))) {
+		switch rel {
+		case "canonical":
+			if m.Canonical == "" {
+				m.Canonical = link.Href
+			}
+		case "alternate":
+			if link.Lang != "" {
+				m.Alternates = append(m.Alternates, link)
+			} else if feedTypes[link.Type] {
+				m.Feeds = append(m.Feeds, link)
+			}
+		case "icon":
+			if m.Favicon == "" {
+				m.Favicon = link.Href
+			}
+		case "apple-touch-icon":
+			// Only used when there is no plain icon
+			if *favicon == "" {
+				*favicon = link.Href
+			}
+		}
+	}
+}
+
+func setFirst(m map[string]string, key, value string) {
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
@@ -4,13 +4,35 @@ import (
 	"bufio"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+type RedirectKind int
+
+const (
+	REDIRECT_HTTP RedirectKind = 1 + iota
+	REDIRECT_META_REFRESH
+	REDIRECT_JAVASCRIPT
+)
+
+// One hop taken on the way to a page
+type Redirect struct {
+	From       string
+	To         string
+	StatusCode int
+	Kind       RedirectKind
+}
+
 type Page struct {
-	Document *http.Response
-	root     Element
-	url      string
+	Document  *http.Response
+	root      Element
+	url       string
+	redirects []Redirect
 }
 
 func NewPage() *Page {
@@ -25,7 +47,12 @@ func ParseResp(resp *http.Response) *Page {
 	r := bufio.NewReader(resp.Body)
 	defer resp.Body.Close()
 
-	p := ParseBody(r)
+	// Xml documents such as feeds and SOAP responses get the xml parser
+	parser := NewParser()
+	if isXmlType(resp.Header.Get("Content-Type")) {
+		parser.SetMode(PARSE_XML)
+	}
+	p := parser.ParsePage(r)
 
 	p.Document = resp
 	return p
@@ -45,6 +72,160 @@ func (p *Page) SetUrl(url string) {
 	p.url = url
 }
 
+/*
+	Return a copy of this page whose elements are frozen, see Freeze.
+	Use it to share a page between many goroutines which only read it.
+*/
+func (p *Page) Freeze() *Page {
+	frozen := *p
+	if p.root != nil {
+		frozen.root = Freeze(p.root)
+	}
+	return &frozen
+}
+
+// Return the redirects followed to reach this page, oldest first
+func (p *Page) GetRedirects() []Redirect {
+	return p.redirects
+}
+
+func (p *Page) SetRedirects(redirects []Redirect) {
+	p.redirects = redirects
+}
+
+/*
+	Look for a <meta http-equiv="refresh"> redirect on this page.
+	Returns the absolute url to go to and the delay in seconds,
+	the url is empty if the page doesn't redirect anywhere.
+*/
+func (p *Page) MetaRefresh() (string, int) {
+	if p.root == nil {
+		return "", 0
+	}
+
+	for _, meta := range p.root.AllByTag("meta") {
+		if !strings.EqualFold(strings.TrimSpace(meta.GetAttribute("http-equiv")), "refresh") {
+			continue
+		}
+
+		delay, target := parseRefresh(meta.GetAttribute("content"))
+		if target == "" {
+			continue
+		}
+		return p.resolve(target), delay
+	}
+	return "", 0
+}
+
+// Split a refresh value such as "5; url='/next'" into its delay and url
+func parseRefresh(content string) (int, string) {
+	content = strings.TrimSpace(content)
+
+	end := strings.IndexAny(content, ";,")
+	if end < 0 {
+		end = len(content)
+	}
+	delay, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(content[:end], ".", 2)[0]))
+	if err != nil {
+		delay = 0
+	}
+
+	rest := strings.TrimLeft(content[end:], ";, \t")
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		after := strings.TrimLeft(rest[3:], " \t")
+		if strings.HasPrefix(after, "=") {
+			rest = strings.TrimLeft(after[1:], " \t")
+		}
+	}
+
+	if len(rest) > 0 && (rest[0] == '\'' || rest[0] == '"') {
+		quote := rest[0]
+		rest = rest[1:]
+		if i := strings.IndexByte(rest, quote); i >= 0 {
+			rest = rest[:i]
+		}
+	}
+	return delay, strings.TrimSpace(rest)
+}
+
+/*
+	Scripts which do nothing but send the browser on, such as
+	window.location = "/next" or location.replace('/next'). The whole
+	script must be the one statement, so a redirect inside an if, a
+	function or a comment doesn't count. Only literal urls are understood,
+	nothing is run.
+*/
+var jsRedirects = []*regexp.Regexp{
+	regexp.MustCompile(`^(?:(?:window|document|top|self)\.)?location(?:\.href)?\s*=\s*(?:"([^"]*)"|'([^']*)')\s*;?$`),
+	regexp.MustCompile(`^(?:(?:window|document|top|self)\.)?location\.(?:replace|assign)\(\s*(?:"([^"]*)"|'([^']*)')\s*\)\s*;?$`),
+}
+
+/*
+	Look for a script redirect on this page, in an inline script or the
+	body's onload. Returns the absolute url to go to or empty if none.
+*/
+func (p *Page) JsRedirect() string {
+	if p.root == nil {
+		return ""
+	}
+
+	scripts := []string{}
+	for _, script := range p.root.AllByTag("script") {
+		if script.GetAttribute("src") == "" {
+			scripts = append(scripts, script.GetContent())
+		}
+	}
+	if body := p.root.ByTag("body"); body != nil {
+		scripts = append(scripts, body.GetAttribute("onload"))
+	}
+
+	for _, script := range scripts {
+		for _, pattern := range jsRedirects {
+			match := pattern.FindStringSubmatch(strings.TrimSpace(script))
+			if match == nil {
+				continue
+			}
+			// Only one of the double or single quoted groups matched
+			if target := strings.TrimSpace(match[1] + match[2]); target != "" {
+				return p.resolve(target)
+			}
+		}
+	}
+	return ""
+}
+
+/*
+	Return where this page redirects to without http, a meta refresh
+	first and then a script. The url is empty if it doesn't redirect.
+*/
+func (p *Page) ClientRedirect() (string, RedirectKind) {
+	if target, _ := p.MetaRefresh(); target != "" {
+		return target, REDIRECT_META_REFRESH
+	}
+	if target := p.JsRedirect(); target != "" {
+		return target, REDIRECT_JAVASCRIPT
+	}
+	return "", 0
+}
+
+// The absolute canonical url the page gives for itself, empty if it has none
+func (p *Page) Canonical() string {
+	return p.Metadata().Canonical
+}
+
+// Resolve a url found on this page against the pages own url
+func (p *Page) resolve(ref string) string {
+	base, err := url.Parse(p.url)
+	if err != nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
 /*
 	Convert all relative links on this page to absolute links
 	(useful when saving a file to disk for later viewing)
@@ -82,40 +263,117 @@ func (p *Page) SaveToFile(fileName string) {
 /*
 	Saves a textual markup representation of an
 	Element and all of it's child elements to a file
-	TODO this is recursive (careful of stack overflows)
 */
 func ElementToFile(e Element, out *os.File) (err error) {
-	var tagContent string
+	return WriteHtml(e, out)
+}
 
-	tagContent = "<" + e.GetTagName()
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
 
-	for key, val := range e.GetAttributes() {
-		tagContent = tagContent + " " + key + "=\"" + val + "\""
+/*
+	Write the markup of an element and all of its children.
+	Text and attribute values are escaped, except inside raw text
+	elements such as <script> whose text is written as it is.
+	TODO this is recursive (careful of stack overflows)
+*/
+func WriteHtml(e Element, out io.Writer) (err error) {
+	// Untagged elements only hold text
+	if e.GetTagName() == "" {
+		_, err = io.WriteString(out, textEscaper.Replace(e.base().leadingText()))
+		return err
 	}
 
-	tagContent = tagContent + ">" + e.GetContent()
+	var tagContent string
 
-	_, err = out.Write([]byte(tagContent))
+	tagContent = "<" + e.GetTagName()
 
-	if err != nil {
+	// Sorted so the same element always gives the same markup
+	attrs := e.GetAttributes()
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tagContent = tagContent + " " + key + "=\"" + attrEscaper.Replace(attrs[key]) + "\""
+	}
+
+	tagContent = tagContent + ">"
+	_, err = io.WriteString(out, tagContent)
+	if err != nil || e.GetKind() == ELEM_VOID {
 		return err
 	}
 
-	for _, child := range e.GetChildren() {
-		ElementToFile(child, out)
+	if err = writeInner(e, out); err != nil {
+		return err
 	}
 
 	endTagContent := "</" + e.GetTagName() + ">"
-	_, err = out.Write([]byte(endTagContent))
+	_, err = io.WriteString(out, endTagContent)
 
 	return err
 }
 
+// Write the text and children of an element without its own tags
+func writeInner(e Element, out io.Writer) error {
+	escape := textEscaper.Replace
+	if e.GetKind() == ELEM_RAW {
+		escape = func(s string) string { return s }
+	}
+
+	if _, err := io.WriteString(out, escape(e.base().leadingText())); err != nil {
+		return err
+	}
+	for _, child := range e.GetChildren() {
+		if err := WriteHtml(child, out); err != nil {
+			return err
+		}
+		// Text between this child and the next
+		if _, err := io.WriteString(out, escape(child.GetTail())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the markup of an element and all of its children as a string
+func Html(e Element) string {
+	var out strings.Builder
+	WriteHtml(e, &out)
+	return out.String()
+}
+
+// Return the markup inside an element, leaving out its own tags
+func InnerHtml(e Element) string {
+	var out strings.Builder
+	writeInner(e, &out)
+	return out.String()
+}
+
+/*
+	Find the first element with a matching tag
+	Example: body := page.ByTag("soap:Body")
+*/
+func (p *Page) ByTag(tag interface{}) Element {
+	return p.root.ByTag(tag)
+}
+
+/*
+	Find all elements with a matching tag
+	Example: items := page.AllByTag("item")
+*/
+func (p *Page) AllByTag(tag interface{}) []Element {
+	return p.root.AllByTag(tag)
+}
+
 /*
 	Find the first element matching a given attribute
 	Example: form := page.ByAttribute("id","login-form")
 */
-func (p *Page) ByAttribute(name, value string) Element {
+func (p *Page) ByAttribute(name string, value interface{}) Element {
 	return p.root.ByAttribute(name, value)
 }
 
@@ -123,7 +381,7 @@ func (p *Page) ByAttribute(name, value string) Element {
 	Find all elements matching a given attribute
 	Example: result := page.AllAttribute("class","search-result-div")
 */
-func (p *Page) AllByAttribute(name, value string) []Element {
+func (p *Page) AllByAttribute(name string, value interface{}) []Element {
 	return p.root.AllByAttribute(name, value)
 }
 
@@ -131,7 +389,7 @@ func (p *Page) AllByAttribute(name, value string) []Element {
 	Find the first element with this id
 	Example: form := page.ById("login-form")
 */
-func (p *Page) ById(id string) Element {
+func (p *Page) ById(id interface{}) Element {
 	return p.root.ById(id)
 }
 
@@ -139,7 +397,7 @@ func (p *Page) ById(id string) Element {
 	Find all elements with this id
 	Example: result := page.AllById("search-result-div")
 */
-func (p *Page) AllById(id string) []Element {
+func (p *Page) AllById(id interface{}) []Element {
 	return p.root.AllById(id)
 }
 
@@ -147,7 +405,7 @@ func (p *Page) AllById(id string) []Element {
 	Find the first element with this class
 	Example: form := page.ByClass("container-div")
 */
-func (p *Page) ByClass(class string) Element {
+func (p *Page) ByClass(class interface{}) Element {
 	return p.root.ByClass(class)
 }
 
@@ -155,6 +413,22 @@ func (p *Page) ByClass(class string) Element {
 	Find all elements with this class
 	Example: result := page.AllById("news-result-div")
 */
-func (p *Page) AllByClass(class string) []Element {
+func (p *Page) AllByClass(class interface{}) []Element {
 	return p.root.AllByClass(class)
 }
+
+/*
+	Find the first element with all of these classes
+	Example: button := page.ByClasses("btn", "btn-primary")
+*/
+func (p *Page) ByClasses(classes ...string) Element {
+	return p.root.ByClasses(classes...)
+}
+
+/*
+	Find all elements with all of these classes
+	Example: results := page.AllByClasses("result", "organic")
+*/
+func (p *Page) AllByClasses(classes ...string) []Element {
+	return p.root.AllByClasses(classes...)
+}
@@ -51,6 +51,39 @@ func init() {
 
 }
 
+/*
+	Start tags which end elements the page left open, such as a <td>
+	closing the previous cell. Elements are only closed up to the
+	nearest stopAt element so nested lists and tables are left alone.
+*/
+type impliedEnd struct {
+	closes []string
+	stopAt []string
+}
+
+var impliedEnds = map[string]impliedEnd{
+	"td":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"th":     {[]string{"td", "th"}, []string{"tr", "table"}},
+	"tr":     {[]string{"tr", "td", "th"}, []string{"table"}},
+	"thead":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tbody":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tfoot":  {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"li":     {[]string{"li"}, []string{"ul", "ol", "menu"}},
+	"dt":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"dd":     {[]string{"dt", "dd"}, []string{"dl"}},
+	"option": {[]string{"option"}, []string{"select", "datalist"}},
+	"p":      {[]string{"p"}, []string{"div", "td", "th", "li", "body", "table"}},
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 func GetElemKind(tagName string) ElemKind {
 	kind := kindMap[tagName]
 	if kind < 1 {
@@ -60,19 +93,41 @@ func GetElemKind(tagName string) ElemKind {
 
 }
 
+type ParseMode int
+
+const (
+	// Html5 rules, tag names are lower case and void and raw text tags are known
+	PARSE_HTML ParseMode = 1 + iota
+	// Well formed xml, tag names keep their case and namespace prefix
+	PARSE_XML
+)
+
 type Parser struct {
 	page          *Page
 	currentParent Element
 	lastElement   Element
+	mode          ParseMode
 }
 
 func NewParser() *Parser {
 	p := Parser{}
+	p.mode = PARSE_HTML
 	return &p
 }
 
+func (p *Parser) GetMode() ParseMode {
+	return p.mode
+}
+
+func (p *Parser) SetMode(mode ParseMode) {
+	p.mode = mode
+}
+
 // Create a page from a http body
 func (p *Parser) ParsePage(r io.Reader) *Page {
+	if p.mode == PARSE_XML {
+		return p.parseXml(r)
+	}
 
 	p.page = NewPage()
 
@@ -99,6 +154,7 @@ func (p *Parser) ParsePage(r io.Reader) *Page {
 			switch tokenType {
 
 			case html.StartTagToken:
+				p.closeImplied(token.Data)
 
 				// <tag>
 				// type Token struct {
@@ -120,20 +176,14 @@ func (p *Parser) ParsePage(r io.Reader) *Page {
 
 			case html.TextToken: // text between start and end tag
 
-				if lastTokenType == html.StartTagToken {
-					// Last parsed element can support textual content
-					p.lastElement.SetContent(token.Data)
+				if lastTokenType == html.StartTagToken && p.lastElement != nil && isRawKind(p.lastElement.GetKind()) {
+					// Raw elements such as <script> hold their text themselves
+					p.lastElement.SetContent(p.lastElement.GetContent() + token.Data)
 				} else if p.currentParent != nil {
-					// The last parsed element can't hold text, assume this is for the parent node
-					p.currentParent.SetContent(token.Data)
+					p.addText(token.Data)
 				}
 			case html.EndTagToken: // </tag>
-				//p.handleElement(token)
-
-				// If a tag has ended it's parent now becomes the parent node
-				if p.currentParent.GetParent() != nil {
-					p.currentParent = p.currentParent.GetParent()
-				}
+				p.closeElement(token.Data)
 			case html.SelfClosingTagToken: // <tag/>
 				p.lastElement = p.handleElement(token)
 			}
@@ -147,6 +197,78 @@ func (p *Parser) ParsePage(r io.Reader) *Page {
 
 }
 
+/*
+	Text goes after whatever the current parent holds so far: into the
+	parent itself if it has no children yet, otherwise onto the tail of
+	its last child. This keeps text in document order around child tags.
+*/
+func (p *Parser) addText(text string) {
+	children := p.currentParent.GetChildren()
+	if len(children) == 0 {
+		p.currentParent.SetContent(p.currentParent.GetContent() + text)
+	} else {
+		last := children[len(children)-1]
+		last.SetTail(last.GetTail() + text)
+	}
+}
+
+/*
+	An end tag closes the nearest open element with the same name.
+	End tags with no matching open element in scope are ignored, as
+	browsers do, rather than closing some other element.
+*/
+func (p *Parser) closeElement(tagName string) {
+	stopAt := []string{"td", "th", "table"}
+	switch tagName {
+	case "table":
+		stopAt = nil
+	case "tr", "td", "th", "thead", "tbody", "tfoot", "caption":
+		stopAt = []string{"table"}
+	}
+
+	for open := p.currentParent; open != nil; open = open.GetParent() {
+		if open.GetTagName() == tagName {
+			// The root stays open so anything after it still has a parent
+			if open != p.page.root {
+				p.currentParent = open.GetParent()
+			}
+			return
+		}
+		if containsTag(stopAt, open.GetTagName()) {
+			return
+		}
+	}
+}
+
+// Close any elements a new start tag implicitly ends, see impliedEnds
+func (p *Parser) closeImplied(tagName string) {
+	rule, ok := impliedEnds[tagName]
+	if !ok {
+		return
+	}
+
+	for {
+		var closing Element
+		for open := p.currentParent; open != nil && open != p.page.root; open = open.GetParent() {
+			if containsTag(rule.closes, open.GetTagName()) {
+				closing = open
+				break
+			}
+			if containsTag(rule.stopAt, open.GetTagName()) {
+				break
+			}
+		}
+		if closing == nil {
+			return
+		}
+		p.currentParent = closing.GetParent()
+	}
+}
+
+func isRawKind(kind ElemKind) bool {
+	return kind == ELEM_RAW || kind == ELEM_ESC_RAW
+}
+
 func (p *Parser) handleElement(token html.Token) Element {
 	foundElem := buildElement(token)
 
@@ -170,7 +292,8 @@ func buildElement(token html.Token) Element {
 
 	var newElem Element
 
-	elemName := token.DataAtom.String()
+	// Data holds the lower case name of tags the atom table doesn't know too
+	elemName := token.Data
 	switch elemName {
 	case "form":
 		newElem = NewForm()
@@ -0,0 +1,448 @@
+package element
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrNoContent = errors.New("grawl: couldn't find the main content of the page")
+
+/*
+	The main content of an article page with the navigation, ads,
+	comments and other clutter around it removed.
+	Published is the zero time if the page doesn't give a date.
+*/
+type Article struct {
+	Title     string
+	Byline    string
+	Published time.Time
+	// A cleaned copy of the content, changing it doesn't change the page
+	Content Element
+	Html    string
+	Text    string
+}
+
+var (
+	unlikelyCandidate = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|gdpr|header|menu|modal|nav|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental`)
+	maybeCandidate    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow|story|entry|post`)
+	positiveClass     = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeClass     = regexp.MustCompile(`(?i)(^|[\s_-])ad([\s_-]|$)|advert|banner|combx|comment|contact|foot|gdpr|hidden|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|social`)
+	bylineClass       = regexp.MustCompile(`(?i)byline|author|dateline|writtenby`)
+)
+
+// Tags which are never part of an article
+var clutterTags = map[string]bool{
+	"aside": true, "button": true, "embed": true, "footer": true, "form": true,
+	"iframe": true, "input": true, "nav": true, "noscript": true, "object": true,
+	"script": true, "select": true, "style": true, "svg": true, "template": true,
+	"textarea": true,
+}
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "li": true, "main": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "tr": true, "ul": true,
+}
+
+// Meta tags which may hold the publish date, best first
+var dateMeta = []string{"article:published_time", "og:published_time", "datepublished", "pubdate", "publishdate", "date", "dc.date", "dcterms.created"}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+/*
+	Find the article on a page by scoring elements on how much text
+	they hold and how little of it is links, in the spirit of the
+	readability bookmarklet. The page itself isn't changed.
+	Returns ErrNoContent if nothing looks like an article.
+	Example:
+	article, err := page.MainContent()
+	if err == nil {
+		log.Println(article.Title, article.Byline)
+		log.Println(article.Text)
+	}
+*/
+func (p *Page) MainContent() (*Article, error) {
+	if p.root == nil {
+		return nil, ErrNoContent
+	}
+
+	doc := p.root.Clone()
+	meta := p.Metadata()
+
+	article := Article{}
+	article.Title = articleTitle(doc, meta)
+	article.Byline = articleByline(doc, meta)
+	article.Published = articlePublished(doc, meta)
+
+	removeClutter(doc)
+	scores := scoreCandidates(doc)
+
+	// Candidates are compared in document order so ties go to the first
+	var top Element
+	topScore := 0.0
+	Walk(doc, func(e Element) WalkAction {
+		if score, ok := scores[e]; ok {
+			score *= 1 - linkDensity(e)
+			if top == nil || score > topScore {
+				top, topScore = e, score
+			}
+		}
+		return WALK_CONTINUE
+	})
+	if top == nil {
+		return nil, ErrNoContent
+	}
+
+	content := gatherSiblings(top, topScore, scores)
+	cleanContent(content)
+
+	article.Content = content
+	article.Html = Html(content)
+	article.Text = PlainText(content, 0)
+	if article.Text == "" {
+		return nil, ErrNoContent
+	}
+	return &article, nil
+}
+
+// Drop elements which are clutter by their tag, class, id or visibility
+func removeClutter(doc Element) {
+	clutter := []Element{}
+	Walk(doc, func(e Element) WalkAction {
+		tag := e.GetTagName()
+		if clutterTags[tag] || isHidden(e) {
+			clutter = append(clutter, e)
+			return WALK_SKIP_CHILDREN
+		}
+
+		switch tag {
+		case "html", "body", "article", "main":
+			return WALK_CONTINUE
+		}
+		names := e.GetAttribute("class") + " " + e.GetAttribute("id")
+		if unlikelyCandidate.MatchString(names) && !maybeCandidate.MatchString(names) {
+			clutter = append(clutter, e)
+			return WALK_SKIP_CHILDREN
+		}
+		return WALK_CONTINUE
+	})
+
+	for _, e := range clutter {
+		e.Detach()
+	}
+}
+
+func isHidden(e Element) bool {
+	style := strings.ReplaceAll(strings.ToLower(e.GetAttribute("style")), " ", "")
+	return hasAttr(e, "hidden") || e.GetAttribute("aria-hidden") == "true" ||
+		strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+/*
+	Score the parents of every paragraph like element by the text it holds,
+	grandparents get half. Returns the score of each candidate.
+*/
+func scoreCandidates(doc Element) map[Element]float64 {
+	scores := map[Element]float64{}
+	addScore := func(e Element, score float64) {
+		if _, ok := scores[e]; !ok {
+			scores[e] = initialScore(e)
+		}
+		scores[e] += score
+	}
+
+	Walk(doc, func(e Element) WalkAction {
+		switch e.GetTagName() {
+		case "p", "pre", "td", "blockquote":
+		case "div":
+			// Only divs used as paragraphs, others are scored through their children
+			for _, child := range e.GetChildren() {
+				if blockTags[child.GetTagName()] {
+					return WALK_CONTINUE
+				}
+			}
+		default:
+			return WALK_CONTINUE
+		}
+
+		text := CleanText(e)
+		if len(text) < 25 {
+			return WALK_CONTINUE
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+
+		if parent := e.GetParent(); parent != nil {
+			addScore(parent, score)
+			if grandparent := parent.GetParent(); grandparent != nil {
+				addScore(grandparent, score/2)
+			}
+		}
+		return WALK_CONTINUE
+	})
+	return scores
+}
+
+func initialScore(e Element) float64 {
+	score := classWeight(e)
+	switch e.GetTagName() {
+	case "div", "article", "main":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+// Guess from class and id names whether an element is content or clutter
+func classWeight(e Element) float64 {
+	weight := 0.0
+	for _, name := range []string{e.GetAttribute("class"), e.GetAttribute("id")} {
+		if name == "" {
+			continue
+		}
+		if negativeClass.MatchString(name) {
+			weight -= 25
+		}
+		if positiveClass.MatchString(name) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// The share of an elements text which is inside links
+func linkDensity(e Element) float64 {
+	total := len(CleanText(e))
+	if total == 0 {
+		return 0
+	}
+	links := 0
+	for _, link := range e.AllByTag("a") {
+		links += len(CleanText(link))
+	}
+	return float64(links) / float64(total)
+}
+
+/*
+	Articles are often split over several siblings, such as a lead
+	paragraph next to the body, so take any sibling of the top candidate
+	that scores well or reads like a paragraph
+*/
+func gatherSiblings(top Element, topScore float64, scores map[Element]float64) Element {
+	content := NewBaseElement()
+	content.SetTagName("div")
+
+	parent := top.GetParent()
+	if parent == nil {
+		content.AddChild(top)
+		return content
+	}
+
+	threshold := math.Max(10, topScore*0.2)
+	topClass := top.GetAttribute("class")
+	for _, sibling := range parent.GetChildren() {
+		keep := sibling == top
+		if !keep {
+			bonus := 0.0
+			if topClass != "" && sibling.GetAttribute("class") == topClass {
+				bonus = topScore * 0.2
+			}
+			score, scored := scores[sibling]
+			if scored && score*(1-linkDensity(sibling))+bonus >= threshold {
+				keep = true
+			} else if sibling.GetTagName() == "p" {
+				text := CleanText(sibling)
+				density := linkDensity(sibling)
+				keep = (len(text) > 80 && density < 0.25) ||
+					(len(text) > 0 && density == 0 && strings.HasSuffix(text, "."))
+			}
+		}
+		if keep {
+			// Moving the sibling leaves its tail behind, the text up to the next sibling belongs with it
+			tail := sibling.GetTail()
+			content.AddChild(sibling)
+			sibling.SetTail(tail)
+		}
+	}
+	return content
+}
+
+// Remove blocks inside the content which look more like clutter than text
+func cleanContent(content Element) {
+	clutter := []Element{}
+	Walk(content, func(e Element) WalkAction {
+		if e == content {
+			return WALK_CONTINUE
+		}
+
+		for key := range e.GetAttributes() {
+			if key == "style" || strings.HasPrefix(key, "on") {
+				e.RemoveAttribute(key)
+			}
+		}
+
+		switch e.GetTagName() {
+		case "div", "section", "ul", "ol", "table", "dl", "header", "h1", "h2", "h3":
+		default:
+			return WALK_CONTINUE
+		}
+		if isClutter(e) {
+			clutter = append(clutter, e)
+			return WALK_SKIP_CHILDREN
+		}
+		return WALK_CONTINUE
+	})
+
+	for _, e := range clutter {
+		e.Detach()
+	}
+}
+
+func isClutter(e Element) bool {
+	weight := classWeight(e)
+	if weight < 0 {
+		return true
+	}
+
+	text := CleanText(e)
+	if strings.Count(text, ",") >= 10 {
+		return false
+	}
+
+	tag := e.GetTagName()
+	paragraphs := len(e.AllByTag("p"))
+	images := len(e.AllByTag("img"))
+	items := len(e.AllByTag("li"))
+	density := linkDensity(e)
+
+	switch {
+	case tag != "ul" && tag != "ol" && items > paragraphs*2 && items > 5:
+		return true
+	case !isHeading(tag) && len(text) < 25 && (images == 0 || images > 2):
+		return true
+	case weight < 25 && density > 0.2:
+		return true
+	case weight >= 25 && density > 0.5:
+		return true
+	}
+	return false
+}
+
+func isHeading(tag string) bool {
+	return len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6'
+}
+
+// Use the pages own title without the site name most sites add to it
+func articleTitle(doc Element, meta *Metadata) string {
+	title := meta.OpenGraph["og:title"]
+	if title == "" {
+		title = meta.Title
+	}
+	if title == "" {
+		if h1 := doc.ByTag("h1"); h1 != nil {
+			return CleanText(h1)
+		}
+		return ""
+	}
+
+	for _, sep := range []string{" | ", " - ", " – ", " — ", " :: ", " » "} {
+		if i := strings.LastIndex(title, sep); i > 0 {
+			if head := strings.TrimSpace(title[:i]); len(strings.Fields(head)) >= 3 {
+				return head
+			}
+			break
+		}
+	}
+	return title
+}
+
+func articleByline(doc Element, meta *Metadata) string {
+	if author := meta.Meta["author"]; author != "" {
+		return author
+	}
+
+	byline := ""
+	Walk(doc, func(e Element) WalkAction {
+		if clutterTags[e.GetTagName()] && e.GetTagName() != "footer" {
+			return WALK_SKIP_CHILDREN
+		}
+		names := e.GetAttribute("class") + " " + e.GetAttribute("id")
+		isByline := e.GetAttribute("rel") == "author" ||
+			strings.Contains(e.GetAttribute("itemprop"), "author") ||
+			bylineClass.MatchString(names)
+		if !isByline {
+			return WALK_CONTINUE
+		}
+
+		text := CleanText(e)
+		if text != "" && len(text) < 100 {
+			byline = text
+			return WALK_STOP
+		}
+		return WALK_CONTINUE
+	})
+	return byline
+}
+
+func articlePublished(doc Element, meta *Metadata) time.Time {
+	for _, key := range dateMeta {
+		value := meta.Meta[key]
+		if value == "" {
+			value = meta.OpenGraph[key]
+		}
+		if t, ok := parseDate(value); ok {
+			return t
+		}
+	}
+
+	var published time.Time
+	Walk(doc, func(e Element) WalkAction {
+		value := ""
+		if e.GetAttribute("itemprop") == "datePublished" {
+			value = e.GetAttribute("content")
+			if value == "" {
+				value = e.GetAttribute("datetime")
+			}
+		} else if e.GetTagName() == "time" {
+			value = e.GetAttribute("datetime")
+		}
+		if t, ok := parseDate(value); ok {
+			published = t
+			return WALK_STOP
+		}
+		return WALK_CONTINUE
+	})
+	return published
+}
+
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,469 @@
+package element
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The width ToMarkdown and ToText wrap paragraphs to
+const TEXT_WIDTH = 80
+
+// Stands in for a <br> until paragraphs are split into lines
+const hardBreak = "\u2028"
+
+// Tags with nothing worth rendering
+var unrenderedTags = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "noscript": true,
+	"title": true, "meta": true, "link": true, "svg": true, "input": true, "select": true,
+	"textarea": true, "iframe": true, "object": true, "embed": true,
+}
+
+// Tags laid out as blocks on top of the ones readability knows
+var renderBlockTags = map[string]bool{
+	"html": true, "body": true, "caption": true, "center": true, "details": true,
+	"dir": true, "fieldset": true, "legend": true, "menu": true, "summary": true,
+	"tbody": true, "tfoot": true, "thead": true,
+}
+
+var (
+	markdownSpecial   = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`)
+	markdownLineStart = regexp.MustCompile(`^([#>+-]( |$)|[-=]+$)`)
+	markdownNumbered  = regexp.MustCompile(`^[0-9]+[.)]( |$)`)
+	whiteSpace        = regexp.MustCompile(`\s+`)
+)
+
+type renderer struct {
+	markdown bool
+}
+
+/*
+	Render an element as Markdown, wrapping paragraphs at TEXT_WIDTH.
+	Headings, lists, links, emphasis, code, tables and images are kept,
+	anything else is reduced to its text.
+*/
+func (e *BaseElement) ToMarkdown() string {
+	return Markdown(e.elem(), TEXT_WIDTH)
+}
+
+/*
+	Render an element as plain text wrapped at TEXT_WIDTH,
+	with a blank line between blocks and tables laid out in columns
+*/
+func (e *BaseElement) ToText() string {
+	return PlainText(e.elem(), TEXT_WIDTH)
+}
+
+// Render an element as Markdown wrapped at width, 0 doesn't wrap
+func Markdown(e Element, width int) string {
+	return render(e, true, width)
+}
+
+// Render an element as plain text wrapped at width, 0 doesn't wrap
+func PlainText(e Element, width int) string {
+	return render(e, false, width)
+}
+
+func render(e Element, markdown bool, width int) string {
+	r := renderer{markdown}
+
+	var blocks []string
+	if isRenderBlock(e.GetTagName()) {
+		blocks = r.blocks(e, width)
+	} else {
+		blocks = r.paragraph(r.inline(e), width)
+	}
+
+	// Trailing spaces make no difference to the reader but plenty to a diff
+	lines := strings.Split(strings.Join(blocks, "\n\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isRenderBlock(tag string) bool {
+	return blockTags[tag] || renderBlockTags[tag]
+}
+
+// Render an element laid out as a block
+func (r *renderer) blocks(e Element, width int) []string {
+	tag := e.GetTagName()
+	switch {
+	case unrenderedTags[tag]:
+		return nil
+	case isHeading(tag):
+		text := strings.TrimSpace(strings.ReplaceAll(r.inlineChildren(e), hardBreak, " "))
+		if text == "" {
+			return nil
+		}
+		if r.markdown {
+			level, _ := strconv.Atoi(tag[1:])
+			return []string{strings.Repeat("#", level) + " " + text}
+		}
+		return []string{text}
+	case tag == "p":
+		return r.paragraph(r.inlineChildren(e), width)
+	case tag == "ul" || tag == "ol":
+		return r.list(e, width)
+	case tag == "pre":
+		return r.code(e)
+	case tag == "blockquote":
+		inner := strings.Join(r.container(e, width-2), "\n\n")
+		if inner == "" {
+			return nil
+		}
+		return []string{prefixLines(inner, "> ", "> ")}
+	case tag == "table":
+		return r.table(e)
+	case tag == "hr":
+		return []string{"---"}
+	}
+	return r.container(e, width)
+}
+
+/*
+	Render the children of a block, text and inline children between
+	block children are gathered into paragraphs
+*/
+func (r *renderer) container(e Element, width int) []string {
+	blocks := []string{}
+	var inline strings.Builder
+	flush := func() {
+		blocks = append(blocks, r.paragraph(inline.String(), width)...)
+		inline.Reset()
+	}
+
+	inline.WriteString(r.text(e.base().leadingText()))
+	for _, child := range e.GetChildren() {
+		if isRenderBlock(child.GetTagName()) || unrenderedTags[child.GetTagName()] {
+			flush()
+			blocks = append(blocks, r.blocks(child, width)...)
+		} else {
+			inline.WriteString(r.inline(child))
+		}
+		inline.WriteString(r.text(child.GetTail()))
+	}
+	flush()
+	return blocks
+}
+
+// Render an element laid out inline, as part of a paragraph
+func (r *renderer) inline(e Element) string {
+	tag := e.GetTagName()
+	switch tag {
+	case "br":
+		return hardBreak
+	case "img":
+		alt := strings.TrimSpace(whiteSpace.ReplaceAllString(e.GetAttribute("alt"), " "))
+		src := strings.TrimSpace(e.GetAttribute("src"))
+		if !r.markdown || src == "" {
+			return r.text(alt)
+		}
+		return "![" + r.text(alt) + "](" + markdownUrl(src) + ")"
+	case "code", "kbd", "samp", "tt":
+		code := whiteSpace.ReplaceAllString(Text(e), " ")
+		if !r.markdown || strings.TrimSpace(code) == "" {
+			return code
+		}
+		return codeSpan(code)
+	}
+	if unrenderedTags[tag] {
+		return ""
+	}
+
+	inner := r.inlineChildren(e)
+	if !r.markdown {
+		return inner
+	}
+
+	switch tag {
+	case "a":
+		href := strings.TrimSpace(e.GetAttribute("href"))
+		if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return inner
+		}
+		return hug(inner, "[", "]("+markdownUrl(href)+")")
+	case "strong", "b":
+		return hug(inner, "**", "**")
+	case "em", "i", "cite", "dfn":
+		return hug(inner, "*", "*")
+	case "del", "s", "strike":
+		return hug(inner, "~~", "~~")
+	}
+	return inner
+}
+
+// Render the text and children of an element as one run of inline text
+func (r *renderer) inlineChildren(e Element) string {
+	var inline strings.Builder
+	inline.WriteString(r.text(e.base().leadingText()))
+	for _, child := range e.GetChildren() {
+		if isRenderBlock(child.GetTagName()) {
+			// A block inside an inline element is kept apart by spaces at least
+			inline.WriteString(" " + r.inline(child) + " ")
+		} else {
+			inline.WriteString(r.inline(child))
+		}
+		inline.WriteString(r.text(child.GetTail()))
+	}
+	return inline.String()
+}
+
+// Collapse white space in text from the page and escape it for markdown
+func (r *renderer) text(text string) string {
+	text = whiteSpace.ReplaceAllString(text, " ")
+	if r.markdown {
+		text = markdownSpecial.Replace(text)
+	}
+	return text
+}
+
+// Put markup around inline text, keeping the spaces around it outside
+func hug(inner, open, close string) string {
+	core := strings.TrimSpace(inner)
+	if core == "" {
+		return inner
+	}
+	lead := inner[:strings.Index(inner, core)]
+	trail := inner[len(lead)+len(core):]
+	return lead + open + core + close + trail
+}
+
+func codeSpan(code string) string {
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+func markdownUrl(href string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(href)
+}
+
+// Wrap a run of inline text into a paragraph, nothing if it is only white space
+func (r *renderer) paragraph(inline string, width int) []string {
+	// Each <br> starts a new run of wrapped lines
+	runs := []string{}
+	for _, run := range strings.Split(inline, hardBreak) {
+		run = strings.TrimSpace(whiteSpace.ReplaceAllString(run, " "))
+		if run == "" {
+			continue
+		}
+		lines := wrap(run, width)
+		if r.markdown {
+			// Stop lines being read as headings, lists or quotes
+			for i, line := range lines {
+				if markdownNumbered.MatchString(line) {
+					end := strings.IndexAny(line, ".)")
+					lines[i] = line[:end] + `\` + line[end:]
+				} else if markdownLineStart.MatchString(line) {
+					lines[i] = `\` + line
+				}
+			}
+		}
+		runs = append(runs, strings.Join(lines, "\n"))
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	if !r.markdown {
+		return []string{strings.Join(runs, "\n")}
+	}
+	// A trailing \ is a markdown line break, soft wrapped lines run together
+	return []string{strings.Join(runs, "\\\n")}
+}
+
+// Break text on spaces into lines of at most width runes, long words stay whole
+func wrap(text string, width int) []string {
+	if width <= 0 || utf8.RuneCountInString(text) <= width {
+		return []string{text}
+	}
+
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+func (r *renderer) list(e Element, width int) []string {
+	number := 1
+	if start, err := strconv.Atoi(strings.TrimSpace(e.GetAttribute("start"))); err == nil {
+		number = start
+	}
+
+	items := [][]string{}
+	for _, child := range e.GetChildren() {
+		tag := child.GetTagName()
+		if tag != "li" {
+			// Lists nested straight inside a list belong to the item before them
+			if isRenderBlock(tag) && len(items) > 0 {
+				last := len(items) - 1
+				items[last] = append(items[last], r.blocks(child, width-4)...)
+			}
+			continue
+		}
+		items = append(items, nil)
+		items[len(items)-1] = r.container(child, width-4)
+	}
+
+	rendered := []string{}
+	for _, blocks := range items {
+		marker := "- "
+		if e.GetTagName() == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		rendered = append(rendered, prefixLines(strings.Join(blocks, "\n\n"), marker, indent))
+	}
+	if len(rendered) == 0 {
+		return nil
+	}
+	return []string{strings.Join(rendered, "\n")}
+}
+
+// Prefix the first line of text with first and the rest with others, blank lines stay blank
+func prefixLines(text, first, others string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := others
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" && i > 0 {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *renderer) code(e Element) []string {
+	code := Text(e)
+	// Browsers ignore a newline straight after <pre>
+	code = strings.TrimPrefix(strings.TrimPrefix(code, "\r"), "\n")
+	code = strings.TrimRight(code, "\r\n")
+	if strings.TrimSpace(code) == "" {
+		return nil
+	}
+	if !r.markdown {
+		return []string{code}
+	}
+
+	lang := ""
+	if inner := e.ByTag("code"); inner != nil {
+		for _, class := range strings.Fields(inner.GetAttribute("class")) {
+			for _, prefix := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(class, prefix) {
+					lang = strings.TrimPrefix(class, prefix)
+				}
+			}
+		}
+	}
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return []string{fence + lang + "\n" + code + "\n" + fence}
+}
+
+func (r *renderer) table(e Element) []string {
+	t, err := NewTable(e)
+	if err != nil {
+		return r.container(e, 0)
+	}
+
+	rows := t.Strings()
+	header := append([]string(nil), t.Headers...)
+	if len(header) == 0 && len(rows) > 0 && r.markdown {
+		// Markdown tables need a header row
+		header, rows = rows[0], rows[1:]
+	}
+	if len(header) == 0 && len(rows) == 0 {
+		return nil
+	}
+
+	all := rows
+	if len(header) > 0 {
+		all = append([][]string{header}, rows...)
+	}
+	for _, row := range all {
+		for i, cell := range row {
+			if r.markdown {
+				cell = strings.ReplaceAll(markdownSpecial.Replace(cell), "|", `\|`)
+			}
+			row[i] = cell
+		}
+	}
+
+	widths := []int{}
+	for _, row := range all {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if r.markdown {
+		// A separator needs at least 3 dashes
+		for i := range widths {
+			if widths[i] < 3 {
+				widths[i] = 3
+			}
+		}
+	}
+
+	lines := []string{}
+	for i, row := range all {
+		lines = append(lines, r.tableRow(row, widths))
+		if i == 0 && len(header) > 0 {
+			rule := make([]string, len(widths))
+			for j, w := range widths {
+				rule[j] = strings.Repeat("-", w)
+			}
+			lines = append(lines, r.tableRow(rule, widths))
+		}
+	}
+
+	blocks := []string{}
+	if t.Caption != "" {
+		blocks = append(blocks, r.text(t.Caption))
+	}
+	return append(blocks, strings.Join(lines, "\n"))
+}
+
+func (r *renderer) tableRow(cells []string, widths []int) string {
+	padded := make([]string, len(widths))
+	for i, w := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		padded[i] = cell + strings.Repeat(" ", w-utf8.RuneCountInString(cell))
+	}
+	if r.markdown {
+		return "| " + strings.Join(padded, " | ") + " |"
+	}
+	return strings.Join(padded, "  ")
+}
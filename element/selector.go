@@ -0,0 +1,257 @@
+package element
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	A small subset of css selectors, enough to point at fields in Unmarshal.
+	Supported: tag, *, #id, .class, [attr], [attr=value], [attr^=value],
+	[attr$=value], [attr*=value], the descendant (space) and child (>)
+	combinators and comma separated groups. Anything else, such as
+	:first-child or the + and ~ combinators, is a syntax error.
+*/
+type selector struct {
+	groups [][]selectorStep
+}
+
+// One compound selector and how it relates to the step before it
+type selectorStep struct {
+	match Validator
+	child bool
+}
+
+func compileSelector(text string) (*selector, error) {
+	s := selector{}
+	for _, group := range splitOutside(text, ',') {
+		steps, err := compileGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("grawl: bad selector %q: %w", text, err)
+		}
+		s.groups = append(s.groups, steps)
+	}
+	return &s, nil
+}
+
+func compileGroup(text string) ([]selectorStep, error) {
+	steps := []selectorStep{}
+	child := false
+	rest := strings.TrimSpace(text)
+	if rest == "" {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	for rest != "" {
+		if rest[0] == '>' {
+			if child || len(steps) == 0 {
+				return nil, fmt.Errorf("misplaced >")
+			}
+			child = true
+			rest = strings.TrimSpace(rest[1:])
+			continue
+		}
+
+		end := compoundEnd(rest)
+		match, err := compileCompound(rest[:end])
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, selectorStep{match, child})
+		child = false
+		rest = strings.TrimSpace(rest[end:])
+	}
+	if child {
+		return nil, fmt.Errorf("selector ends with >")
+	}
+	return steps, nil
+}
+
+// Find where a compound selector ends, brackets may hold spaces
+func compoundEnd(text string) int {
+	depth := 0
+	for i, ch := range text {
+		switch {
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case depth == 0 && (ch == ' ' || ch == '\t' || ch == '\n' || ch == '>'):
+			return i
+		}
+	}
+	return len(text)
+}
+
+func compileCompound(text string) (Validator, error) {
+	parts := []Validator{}
+
+	// Left alone these would become part of a tag or class name which never matches
+	if i := indexOutside(text, ":+~>"); i >= 0 {
+		return nil, fmt.Errorf("unsupported %q in %q", text[i], text)
+	}
+
+	// The tag name comes first if there is one
+	i := strings.IndexAny(text, "#.[")
+	if i < 0 {
+		i = len(text)
+	}
+	if tag := text[:i]; tag != "" && tag != "*" {
+		parts = append(parts, MatchTag(Exact(strings.ToLower(tag))))
+	}
+
+	for text = text[i:]; text != ""; {
+		switch text[0] {
+		case '#', '.':
+			end := strings.IndexAny(text[1:], "#.[") + 1
+			if end == 0 {
+				end = len(text)
+			}
+			name := text[1:end]
+			if name == "" {
+				return nil, fmt.Errorf("missing name after %c", text[0])
+			}
+			if text[0] == '#' {
+				parts = append(parts, MatchAttribute("id", Exact(name)))
+			} else {
+				parts = append(parts, MatchClass(Exact(name)))
+			}
+			text = text[end:]
+		case '[':
+			end := strings.IndexByte(text, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [")
+			}
+			v, err := compileAttribute(text[1:end])
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, v)
+			text = text[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", text)
+		}
+	}
+
+	if len(parts) == 0 {
+		return Func(func(e Element) bool { return true }), nil
+	}
+	return And(parts...), nil
+}
+
+func compileAttribute(text string) (Validator, error) {
+	eq := strings.IndexByte(text, '=')
+	if eq < 0 {
+		name := strings.TrimSpace(text)
+		if name == "" {
+			return nil, fmt.Errorf("empty []")
+		}
+		return HasAttribute(name), nil
+	}
+
+	name := text[:eq]
+	op := byte('=')
+	if eq > 0 && strings.IndexByte("^$*", text[eq-1]) >= 0 {
+		op = text[eq-1]
+		name = text[:eq-1]
+	}
+	name = strings.TrimSpace(name)
+	value := strings.Trim(strings.TrimSpace(text[eq+1:]), `"'`)
+	if name == "" {
+		return nil, fmt.Errorf("missing attribute name in [%s]", text)
+	}
+
+	switch op {
+	case '^':
+		return MatchAttribute(name, Prefix(value)), nil
+	case '$':
+		return MatchAttribute(name, Suffix(value)), nil
+	case '*':
+		return MatchAttribute(name, Contains(value)), nil
+	}
+	return MatchAttribute(name, Exact(value)), nil
+}
+
+// Split on sep where it isn't inside brackets or quotes
+func splitOutside(text string, sep rune) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	var quote rune
+	for i, ch := range text {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case ch == sep && depth == 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// Index of the first of chars which isn't inside brackets or quotes, -1 if none
+func indexOutside(text string, chars string) int {
+	depth := 0
+	var quote rune
+	for i, ch := range text {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case depth == 0 && strings.ContainsRune(chars, ch):
+			return i
+		}
+	}
+	return -1
+}
+
+// Return every element below root matching the selector in document order
+func (s *selector) all(root Element) []Element {
+	found := []Element{}
+	for e := range root.Descendants() {
+		for _, steps := range s.groups {
+			if matchSteps(e, root, steps) {
+				found = append(found, e)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Match the last step against e then earlier steps against its ancestors up to root
+func matchSteps(e, root Element, steps []selectorStep) bool {
+	last := len(steps) - 1
+	if !steps[last].match.Validate(e) {
+		return false
+	}
+	if last == 0 {
+		return true
+	}
+
+	child := steps[last].child
+	for parent := e.GetParent(); parent != nil; parent = parent.GetParent() {
+		if matchSteps(parent, root, steps[:last]) {
+			return true
+		}
+		if child || parent == root {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package element
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+	The schema.org style data embedded in a page.
+	JSONLD holds each decoded JSON-LD object, a script holding an array
+	adds each member. Scripts that fail to decode are reported in Errors
+	and the rest of the page is still read.
+*/
+type StructuredData struct {
+	JSONLD    []interface{}
+	Microdata []*Item
+	RDFa      []*Item
+	Errors    []error
+}
+
+/*
+	An item from Microdata (itemscope) or RDFa (typeof).
+	Property values are strings or, for nested items, *Item.
+	Urls from href, src and similar attributes are resolved against the page.
+*/
+type Item struct {
+	Type []string
+	// The itemid or RDFa resource naming this item
+	Id         string
+	Properties map[string][]interface{}
+}
+
+func newItem() *Item {
+	i := Item{}
+	i.Type = []string{}
+	i.Properties = map[string][]interface{}{}
+	return &i
+}
+
+// Return the first value of a property as a string, "" if it has none or it's an item
+func (i *Item) Get(property string) string {
+	for _, value := range i.Properties[property] {
+		if s, ok := value.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// Return the first nested item of a property, nil if there isn't one
+func (i *Item) GetItem(property string) *Item {
+	for _, value := range i.Properties[property] {
+		if item, ok := value.(*Item); ok {
+			return item
+		}
+	}
+	return nil
+}
+
+func (i *Item) add(names string, value interface{}) {
+	for _, name := range strings.Fields(names) {
+		i.Properties[name] = append(i.Properties[name], value)
+	}
+}
+
+/*
+	Extract the JSON-LD, Microdata and RDFa Lite data from the page
+	Example: find the price of a product
+	for _, item := range page.StructuredData().Microdata {
+		if offer := item.GetItem("offers"); offer != nil {
+			log.Println(offer.Get("price"), offer.Get("priceCurrency"))
+		}
+	}
+*/
+func (p *Page) StructuredData() *StructuredData {
+	data := StructuredData{}
+	data.JSONLD = []interface{}{}
+	data.Microdata = []*Item{}
+	data.RDFa = []*Item{}
+	data.Errors = []error{}
+	if p.root == nil {
+		return &data
+	}
+
+	Walk(p.root, func(e Element) WalkAction {
+		if e.GetTagName() == "script" && isJSONLD(e.GetAttribute("type")) {
+			p.addJSONLD(&data, e)
+			return WALK_SKIP_CHILDREN
+		}
+
+		// Only items which aren't a property of another item are top level
+		if hasAttr(e, "itemscope") && !hasAttr(e, "itemprop") {
+			data.Microdata = append(data.Microdata, p.microdataItem(e, map[Element]bool{}))
+		}
+		return WALK_CONTINUE
+	})
+
+	p.addRDFa(&data)
+	return &data
+}
+
+func isJSONLD(scriptType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(scriptType, ";", 2)[0])
+	return strings.EqualFold(mediaType, "application/ld+json")
+}
+
+func (p *Page) addJSONLD(data *StructuredData, script Element) {
+	text := strings.TrimSpace(Text(script))
+	// Some sites wrap the json in an html comment or CDATA block
+	for _, wrap := range [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}} {
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, wrap[0]), wrap[1]))
+	}
+	if text == "" {
+		return
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		data.Errors = append(data.Errors, fmt.Errorf("grawl: bad JSON-LD: %w", err))
+		return
+	}
+	if list, ok := value.([]interface{}); ok {
+		data.JSONLD = append(data.JSONLD, list...)
+	} else {
+		data.JSONLD = append(data.JSONLD, value)
+	}
+}
+
+/*
+	Build the Microdata item for an itemscope element, seen guards
+	against itemref loops
+*/
+func (p *Page) microdataItem(e Element, seen map[Element]bool) *Item {
+	item := newItem()
+	item.Type = strings.Fields(e.GetAttribute("itemtype"))
+	if id := strings.TrimSpace(e.GetAttribute("itemid")); id != "" {
+		item.Id = p.resolve(id)
+	}
+	seen[e] = true
+
+	roots := e.GetChildren()
+	for _, ref := range strings.Fields(e.GetAttribute("itemref")) {
+		if target := p.root.ById(ref); target != nil {
+			roots = append(roots, target)
+		}
+	}
+
+	for _, root := range roots {
+		Walk(root, func(current Element) WalkAction {
+			props := current.GetAttribute("itemprop")
+			isScope := hasAttr(current, "itemscope")
+			if props != "" {
+				if !isScope {
+					item.add(props, p.microdataValue(current))
+				} else if !seen[current] {
+					item.add(props, p.microdataItem(current, seen))
+				}
+			}
+			// Properties below a nested item belong to it
+			if isScope {
+				return WALK_SKIP_CHILDREN
+			}
+			return WALK_CONTINUE
+		})
+	}
+	return item
+}
+
+// The value of a Microdata property as the html spec defines it
+func (p *Page) microdataValue(e Element) string {
+	switch e.GetTagName() {
+	case "meta":
+		return strings.TrimSpace(e.GetAttribute("content"))
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return p.resolveAttr(e, "src")
+	case "a", "area", "link":
+		return p.resolveAttr(e, "href")
+	case "object":
+		return p.resolveAttr(e, "data")
+	case "data", "meter":
+		return strings.TrimSpace(e.GetAttribute("value"))
+	case "time":
+		if hasAttr(e, "datetime") {
+			return strings.TrimSpace(e.GetAttribute("datetime"))
+		}
+	}
+	return CleanText(e)
+}
+
+/*
+	Collect RDFa Lite items. Types are expanded against the nearest vocab,
+	property names are kept as written and prefix declarations are ignored.
+*/
+func (p *Page) addRDFa(data *StructuredData) {
+	type scope struct {
+		item  *Item
+		vocab string
+	}
+
+	var visit func(e Element, current scope)
+	visit = func(e Element, current scope) {
+		if vocab := strings.TrimSpace(e.GetAttribute("vocab")); vocab != "" {
+			current.vocab = vocab
+		}
+
+		props := e.GetAttribute("property")
+		if hasAttr(e, "typeof") {
+			item := newItem()
+			for _, t := range strings.Fields(e.GetAttribute("typeof")) {
+				item.Type = append(item.Type, expandTerm(current.vocab, t))
+			}
+			if resource := e.GetAttribute("resource"); resource != "" {
+				item.Id = p.resolve(strings.TrimSpace(resource))
+			}
+
+			if props != "" && current.item != nil {
+				current.item.add(props, item)
+			} else {
+				data.RDFa = append(data.RDFa, item)
+			}
+			current.item = item
+		} else if props != "" && current.item != nil {
+			current.item.add(props, p.rdfaValue(e))
+		}
+
+		for _, child := range e.GetChildren() {
+			visit(child, current)
+		}
+	}
+	visit(p.root, scope{})
+}
+
+func (p *Page) rdfaValue(e Element) string {
+	if hasAttr(e, "content") {
+		return strings.TrimSpace(e.GetAttribute("content"))
+	}
+	for _, attr := range []string{"resource", "href", "src"} {
+		if hasAttr(e, attr) {
+			return p.resolveAttr(e, attr)
+		}
+	}
+	if e.GetTagName() == "time" && hasAttr(e, "datetime") {
+		return strings.TrimSpace(e.GetAttribute("datetime"))
+	}
+	return CleanText(e)
+}
+
+// Terms which aren't already urls or prefixed are relative to the vocab
+func expandTerm(vocab, term string) string {
+	if vocab == "" || strings.Contains(term, ":") {
+		return term
+	}
+	return vocab + term
+}
+
+func (p *Page) resolveAttr(e Element, attr string) string {
+	value := strings.TrimSpace(e.GetAttribute(attr))
+	if value == "" {
+		return ""
+	}
+	return p.resolve(value)
+}
+
+func hasAttr(e Element, key string) bool {
+	_, ok := e.base().lookupAttribute(key)
+	return ok
+}
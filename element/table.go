@@ -0,0 +1,295 @@
+package element
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrNotTable = errors.New("grawl: element is not a <table>")
+
+// Browsers ignore colspans above this, it also stops silly allocations
+const MAX_COLSPAN = 1000
+
+/*
+	A Table is the text of an html table laid out as a rectangular grid.
+	Cells spanning several rows or columns are repeated into each slot they
+	cover, and rows of nested tables are left out.
+*/
+type Table struct {
+	Caption string
+	// One name per column, empty if the table has no header rows
+	Headers []string
+	Rows    [][]string
+}
+
+type tableRow struct {
+	section string
+	cells   []Element
+}
+
+// A cell still covering slots in the rows below it
+type spanning struct {
+	text string
+	rows int
+}
+
+/*
+	Build a Table from a <table> element.
+	Header rows are the rows in <thead>, or without one the leading
+	rows made only of <th> cells. Several header rows are joined per column.
+	Example:
+	table, err := element.NewTable(page.ById("prices"))
+	for _, row := range table.Maps() {
+		log.Println(row["Product"], row["Price"])
+	}
+*/
+func NewTable(e Element) (*Table, error) {
+	if e == nil || e.GetTagName() != "table" {
+		return nil, ErrNotTable
+	}
+
+	t := Table{}
+	rows := []tableRow{}
+	Walk(e, func(current Element) WalkAction {
+		switch current.GetTagName() {
+		case "table":
+			if current != e {
+				return WALK_SKIP_CHILDREN
+			}
+		case "caption":
+			if t.Caption == "" {
+				t.Caption = CleanText(current)
+			}
+			return WALK_SKIP_CHILDREN
+		case "tr":
+			rows = append(rows, tableRow{rowSection(current, e), rowCells(current)})
+			return WALK_SKIP_CHILDREN
+		}
+		return WALK_CONTINUE
+	})
+
+	grid := expandSpans(rows)
+
+	// Work out how many leading rows are headers
+	headerRows := 0
+	for _, row := range rows {
+		if row.section != "thead" && (row.section == "tbody" || !allHeaderCells(row.cells)) {
+			break
+		}
+		headerRows++
+	}
+
+	width := 0
+	for _, row := range grid {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	for i := range grid {
+		for len(grid[i]) < width {
+			grid[i] = append(grid[i], "")
+		}
+	}
+
+	if headerRows > 0 {
+		t.Headers = joinHeaders(grid[:headerRows], width)
+	}
+	t.Rows = grid[headerRows:]
+	return &t, nil
+}
+
+// Return the tables rows as plain strings, not including the headers
+func (t *Table) Strings() [][]string {
+	rows := make([][]string, len(t.Rows))
+	for i, row := range t.Rows {
+		rows[i] = append([]string(nil), row...)
+	}
+	return rows
+}
+
+// Write the table as csv, headers first if it has them
+func (t *Table) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if len(t.Headers) > 0 {
+		if err := out.Write(t.Headers); err != nil {
+			return err
+		}
+	}
+	if err := out.WriteAll(t.Rows); err != nil {
+		return err
+	}
+	return out.Error()
+}
+
+/*
+	Return each row as a map of header to cell text. Columns without a
+	header are keyed by their index counting from 0, as are all columns
+	when the table has no headers.
+*/
+func (t *Table) Maps() []map[string]string {
+	maps := make([]map[string]string, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		m := make(map[string]string, len(row))
+		for i, cell := range row {
+			key := strconv.Itoa(i)
+			if i < len(t.Headers) && t.Headers[i] != "" {
+				key = t.Headers[i]
+			}
+			if _, taken := m[key]; !taken {
+				m[key] = cell
+			}
+		}
+		maps = append(maps, m)
+	}
+	return maps
+}
+
+// Return every table on the page that isn't nested in another
+func (p *Page) Tables() []*Table {
+	tables := []*Table{}
+	Walk(p.root, func(e Element) WalkAction {
+		if e.GetTagName() != "table" {
+			return WALK_CONTINUE
+		}
+		if t, err := NewTable(e); err == nil {
+			tables = append(tables, t)
+		}
+		return WALK_SKIP_CHILDREN
+	})
+	return tables
+}
+
+// The thead, tbody or tfoot a row sits in, "" if directly in the table
+func rowSection(row Element, table Element) string {
+	for parent := row.GetParent(); parent != nil && parent != table; parent = parent.GetParent() {
+		switch tag := parent.GetTagName(); tag {
+		case "thead", "tbody", "tfoot":
+			return tag
+		}
+	}
+	return ""
+}
+
+func rowCells(row Element) []Element {
+	cells := []Element{}
+	for _, child := range row.GetChildren() {
+		if tag := child.GetTagName(); tag == "td" || tag == "th" {
+			cells = append(cells, child)
+		}
+	}
+	return cells
+}
+
+func allHeaderCells(cells []Element) bool {
+	if len(cells) == 0 {
+		return false
+	}
+	for _, cell := range cells {
+		if cell.GetTagName() != "th" {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Lay the rows out on a grid, copying spanning cells into every slot they
+	cover. Like browsers, rowspans stop at the end of their section and a
+	rowspan of 0 runs to the end of it.
+*/
+func expandSpans(rows []tableRow) [][]string {
+	grid := make([][]string, 0, len(rows))
+	pending := []spanning{}
+
+	for i, row := range rows {
+		if i > 0 && row.section != rows[i-1].section {
+			pending = pending[:0]
+		}
+		remaining := 0
+		for j := i + 1; j < len(rows) && rows[j].section == row.section; j++ {
+			remaining++
+		}
+
+		out := []string{}
+		col := 0
+		// Fill slots taken by cells from rows above
+		fillPending := func() {
+			for col < len(pending) && pending[col].rows > 0 {
+				out = append(out, pending[col].text)
+				pending[col].rows--
+				col++
+			}
+		}
+
+		for _, cell := range row.cells {
+			fillPending()
+
+			text := CleanText(cell)
+			colspan := spanAttr(cell, "colspan", 1)
+			if colspan < 1 {
+				colspan = 1
+			}
+			if colspan > MAX_COLSPAN {
+				colspan = MAX_COLSPAN
+			}
+			rowspan := spanAttr(cell, "rowspan", 1)
+			if rowspan == 0 || rowspan-1 > remaining {
+				rowspan = remaining + 1
+			}
+
+			for k := 0; k < colspan; k++ {
+				out = append(out, text)
+				for len(pending) <= col {
+					pending = append(pending, spanning{})
+				}
+				pending[col] = spanning{text, rowspan - 1}
+				col++
+			}
+		}
+
+		// Spans from above can also cover the end of a row, past any gaps
+		for ; col < len(pending); col++ {
+			if pending[col].rows > 0 {
+				for len(out) < col {
+					out = append(out, "")
+				}
+				out = append(out, pending[col].text)
+				pending[col].rows--
+			}
+		}
+		grid = append(grid, out)
+	}
+	return grid
+}
+
+func spanAttr(cell Element, name string, def int) int {
+	value := strings.TrimSpace(cell.GetAttribute(name))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// Join header rows per column, dropping repeats left by spanning cells
+func joinHeaders(rows [][]string, width int) []string {
+	headers := make([]string, width)
+	for col := 0; col < width; col++ {
+		parts := []string{}
+		for _, row := range rows {
+			text := row[col]
+			if text == "" || (len(parts) > 0 && parts[len(parts)-1] == text) {
+				continue
+			}
+			parts = append(parts, text)
+		}
+		headers[col] = strings.Join(parts, " ")
+	}
+	return headers
+}
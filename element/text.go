@@ -0,0 +1,43 @@
+package element
+
+import (
+	"strings"
+)
+
+/*
+	Return all the text inside an element in document order, including
+	the text of every element below it.
+	Example: for <p>Hello <b>big</b> world</p> this is "Hello big world"
+*/
+func Text(e Element) string {
+	type item struct {
+		elem     Element
+		tailOnly bool
+	}
+
+	var text strings.Builder
+	stack := []item{{e, false}}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if current.tailOnly {
+			text.WriteString(current.elem.GetTail())
+			continue
+		}
+
+		text.WriteString(current.elem.base().leadingText())
+
+		// Each child is followed by its tail, pushed in reverse to pop in order
+		children := current.elem.GetChildren()
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, item{children[i], true}, item{children[i], false})
+		}
+	}
+	return text.String()
+}
+
+// Like Text but with runs of white space collapsed to single spaces and trimmed
+func CleanText(e Element) string {
+	return strings.Join(strings.Fields(Text(e)), " ")
+}
@@ -0,0 +1,139 @@
+package element
+
+import (
+	"iter"
+)
+
+type WalkAction int
+
+const (
+	// Carry on into this elements children
+	WALK_CONTINUE WalkAction = iota
+	// Don't visit this elements children but carry on with the rest
+	WALK_SKIP_CHILDREN
+	// Stop walking altogether
+	WALK_STOP
+)
+
+/*
+	Visit root and everything below it in document order,
+	the visitor decides whether to go into each elements children.
+	Example: find all links outside of the page navigation
+	element.Walk(page.ById("content"), func(e element.Element) element.WalkAction {
+		if e.GetTagName() == "nav" {
+			return element.WALK_SKIP_CHILDREN
+		}
+		if e.GetTagName() == "a" {
+			links = append(links, e)
+		}
+		return element.WALK_CONTINUE
+	})
+*/
+func Walk(root Element, visit func(Element) WalkAction) {
+	if root == nil {
+		return
+	}
+
+	// Children are pushed in reverse so they come off the stack in order
+	stack := []Element{root}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch visit(current) {
+		case WALK_STOP:
+			return
+		case WALK_SKIP_CHILDREN:
+			continue
+		}
+
+		children := current.GetChildren()
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, children[i])
+		}
+	}
+}
+
+/*
+	Iterate over every element below this one in document order,
+	not including this element itself.
+	Example:
+	for e := range elem.Descendants() {
+		log.Println(e.GetTagName())
+	}
+*/
+func (e *BaseElement) Descendants() iter.Seq[Element] {
+	self := e.elem()
+	return func(yield func(Element) bool) {
+		Walk(self, func(current Element) WalkAction {
+			if current == self {
+				return WALK_CONTINUE
+			}
+			if !yield(current) {
+				return WALK_STOP
+			}
+			return WALK_CONTINUE
+		})
+	}
+}
+
+// Iterate over this elements parent, its parent and so on up to the root
+func (e *BaseElement) Ancestors() iter.Seq[Element] {
+	return func(yield func(Element) bool) {
+		for parent := e.GetParent(); parent != nil; parent = parent.GetParent() {
+			if !yield(parent) {
+				return
+			}
+		}
+	}
+}
+
+/*
+	Return this element or its nearest ancestor matching the validator,
+	nil if there is none
+	Example: find the form an input belongs to
+	v, _ := element.NewTagValidator("form")
+	form := input.Closest(v)
+*/
+func (e *BaseElement) Closest(v Validator) Element {
+	if v.Validate(e.elem()) {
+		return e.elem()
+	}
+	for parent := range e.Ancestors() {
+		if v.Validate(parent) {
+			return parent
+		}
+	}
+	return nil
+}
+
+/*
+	Return the next sibling element or nil if this is the last child.
+	Text between elements is held in their tails so is never a sibling.
+*/
+func (e *BaseElement) NextSibling() Element {
+	return e.Next()
+}
+
+// Return the previous sibling element or nil if this is the first child
+func (e *BaseElement) PrevSibling() Element {
+	return e.Prev()
+}
+
+/*
+	Return this elements position among its parents children,
+	counting from 0, or -1 if it has no parent
+*/
+func (e *BaseElement) Index() int {
+	parent := e.GetParent()
+	if parent == nil {
+		return -1
+	}
+
+	for index, sibling := range parent.GetChildren() {
+		if sibling == e.elem() {
+			return index
+		}
+	}
+	return -1
+}
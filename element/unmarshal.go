@@ -0,0 +1,274 @@
+package element
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRequired = errors.New("grawl: required element not found")
+
+var (
+	elementType = reflect.TypeOf((*Element)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	urlType     = reflect.TypeOf(url.URL{})
+)
+
+// The options in a grawl:"..." struct tag
+type fieldTag struct {
+	css      string
+	attr     string
+	layout   string
+	required bool
+}
+
+// A tagged field with its selector compiled, see planFields
+type fieldPlan struct {
+	index int
+	name  string
+	tag   fieldTag
+	sel   *selector
+}
+
+// Field plans by struct type, so a slice of structs compiles each selector once
+var fieldPlans sync.Map
+
+/*
+	Fill the struct v points to from the elements below root, following
+	the grawl struct tags on its fields. Fields without a tag are left alone.
+	Tag options are separated by ; (outside brackets and quotes) and are
+	css=selector   the element to read, by default root itself
+	attr=name      read an attribute instead of the elements text
+	layout=layout  the time.Parse layout for time fields, RFC 3339 by default
+	required       fail with ErrRequired if nothing matches
+
+	Fields may be strings, bools, ints, uints, floats, time.Time, url.URL,
+	Element, pointers to any of those, nested structs (read from the matched
+	element) and slices of any of those, which get one value per match.
+	Numbers are read as written, use a string field for text such as "1,200".
+	Example:
+	type Story struct {
+		Title  string   `grawl:"css=a.storylink;required"`
+		Link   *url.URL `grawl:"css=a.storylink;attr=href"`
+		Points int      `grawl:"css=span.score"`
+	}
+	var stories struct {
+		Stories []Story `grawl:"css=tr.athing"`
+	}
+	err := page.Unmarshal(&stories)
+*/
+func Unmarshal(root Element, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("grawl: Unmarshal needs a pointer to a struct, not %T", v)
+	}
+	if root == nil {
+		return fmt.Errorf("grawl: Unmarshal into %T from a nil element", v)
+	}
+	return unmarshalStruct(root, rv.Elem(), rv.Elem().Type().Name())
+}
+
+// Unmarshal from the whole page, see the Unmarshal function
+func (p *Page) Unmarshal(v interface{}) error {
+	return Unmarshal(p.root, v)
+}
+
+func unmarshalStruct(root Element, rv reflect.Value, path string) error {
+	plans, err := planFields(rv.Type(), path)
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		name := path + "." + plan.name
+		tag := plan.tag
+
+		matches := []Element{root}
+		if plan.sel != nil {
+			matches = plan.sel.all(root)
+		}
+
+		if tag.required && len(matches) == 0 {
+			return fmt.Errorf("%w: %s (css=%s)", ErrRequired, name, tag.css)
+		}
+
+		fv := rv.Field(plan.index)
+		if fv.Kind() == reflect.Slice && fv.Type() != reflect.TypeOf([]byte(nil)) {
+			slice := reflect.MakeSlice(fv.Type(), len(matches), len(matches))
+			for j, match := range matches {
+				if err := setField(match, slice.Index(j), tag, fmt.Sprintf("%s[%d]", name, j)); err != nil {
+					return err
+				}
+			}
+			fv.Set(slice)
+			continue
+		}
+
+		if len(matches) > 0 {
+			if err := setField(matches[0], fv, tag, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Parse the tags and compile the selectors of a struct type's fields, once per type
+func planFields(rt reflect.Type, path string) ([]fieldPlan, error) {
+	if cached, ok := fieldPlans.Load(rt); ok {
+		return cached.([]fieldPlan), nil
+	}
+
+	plans := []fieldPlan{}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		text, ok := field.Tag.Lookup("grawl")
+		if !ok || text == "-" {
+			continue
+		}
+		name := path + "." + field.Name
+		if !field.IsExported() {
+			return nil, fmt.Errorf("grawl: %s has a grawl tag but isn't exported", name)
+		}
+
+		tag, err := parseFieldTag(text)
+		if err != nil {
+			return nil, fmt.Errorf("grawl: %s: %w", name, err)
+		}
+
+		plan := fieldPlan{index: i, name: field.Name, tag: tag}
+		if tag.css != "" {
+			plan.sel, err = compileSelector(tag.css)
+			if err != nil {
+				return nil, fmt.Errorf("grawl: %s: %w", name, err)
+			}
+		}
+		plans = append(plans, plan)
+	}
+
+	fieldPlans.Store(rt, plans)
+	return plans, nil
+}
+
+func parseFieldTag(text string) (fieldTag, error) {
+	tag := fieldTag{}
+	// A ; inside brackets or quotes belongs to the selector, as in [content="a;b"]
+	for _, option := range splitOutside(text, ';') {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "":
+		case "css":
+			tag.css = strings.TrimSpace(value)
+		case "attr":
+			tag.attr = strings.TrimSpace(value)
+		case "layout":
+			tag.layout = value
+		case "required":
+			tag.required = true
+		default:
+			return tag, fmt.Errorf("unknown grawl tag option %q", key)
+		}
+	}
+	return tag, nil
+}
+
+// Set a single value from the element it was matched to
+func setField(e Element, v reflect.Value, tag fieldTag, name string) error {
+	switch {
+	case v.Type() == elementType:
+		v.Set(reflect.ValueOf(e))
+		return nil
+	case v.Kind() == reflect.Ptr:
+		target := reflect.New(v.Type().Elem())
+		if err := setField(e, target.Elem(), tag, name); err != nil {
+			return err
+		}
+		v.Set(target)
+		return nil
+	case v.Kind() == reflect.Struct && v.Type() != timeType && v.Type() != urlType:
+		return unmarshalStruct(e, v, name)
+	}
+
+	var text string
+	if tag.attr != "" {
+		text = strings.TrimSpace(e.GetAttribute(tag.attr))
+	} else {
+		text = CleanText(e)
+	}
+
+	if v.Kind() == reflect.String {
+		v.SetString(text)
+		return nil
+	}
+
+	// An empty value leaves anything other than a string at its zero value
+	if text == "" {
+		if tag.required {
+			return fmt.Errorf("%w: %s is empty", ErrRequired, name)
+		}
+		return nil
+	}
+
+	if err := convert(text, v, tag); err != nil {
+		return fmt.Errorf("grawl: %s: %w", name, err)
+	}
+	return nil
+}
+
+func convert(text string, v reflect.Value, tag fieldTag) error {
+	switch v.Type() {
+	case timeType:
+		layout := tag.layout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, text)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case urlType:
+		u, err := url.Parse(text)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("can't unmarshal into a %s", v.Type())
+	}
+	return nil
+}
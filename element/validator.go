@@ -3,6 +3,7 @@ package element
 import (
 	"github.com/tlowry/grawl/util"
 	"regexp"
+	"strings"
 )
 
 // Validators : a generic way to compare html elements in document Searches
@@ -27,6 +28,10 @@ type BaseValidator struct {
 
 	or a standard go regular expression:
 	Example: NewBaseValidator("my-content-div*")
+
+	Which one is guessed from the characters in text, so literals such as
+	"price.now" are treated as regular expressions. New code should use
+	the Matcher based validators instead, such as MatchTag.
 */
 func NewBaseValidator(text string) (b *BaseValidator, err error) {
 	b = &BaseValidator{}
@@ -63,6 +68,7 @@ func (t *BaseValidator) FirstOnly() bool {
 type TagValidator struct {
 	*BaseValidator
 	wantedTag string
+	matcher   Matcher
 }
 
 /*
@@ -76,12 +82,24 @@ type TagValidator struct {
 */
 func NewTagValidator(tagName string) (*TagValidator, error) {
 	b, err := NewBaseValidator(tagName)
-	v := TagValidator{b, ""}
+	v := TagValidator{BaseValidator: b}
 	v.wantedTag = tagName
 	return &v, err
 }
 
+/*
+	Construct a TagValidator which matches tag names with a Matcher
+	Example: find all headings
+	MatchTag(Regex("^h[1-6]$"))
+*/
+func MatchTag(m Matcher) *TagValidator {
+	return &TagValidator{BaseValidator: &BaseValidator{}, matcher: m}
+}
+
 func (t TagValidator) Validate(e Element) bool {
+	if t.matcher != nil {
+		return t.matcher.Match(e.GetTagName())
+	}
 	if t.regex != nil {
 		if t.regex.MatchString(e.GetTagName()) {
 			return true
@@ -97,6 +115,7 @@ attribute value pair*/
 type AttributeValidator struct {
 	*BaseValidator
 	Key, Val string
+	matcher  Matcher
 }
 
 /*
@@ -112,14 +131,29 @@ func NewAttributeValidator(key, text string) (*AttributeValidator, error) {
 	b, err := NewBaseValidator(text)
 
 	if err == nil {
-		a := AttributeValidator{b, key, text}
+		a := AttributeValidator{BaseValidator: b, Key: key, Val: text}
 		return &a, err
 	}
 	return nil, err
 
 }
 
+/*
+	Construct an AttributeValidator which matches the value of the key
+	attribute with a Matcher, elements without the attribute never match
+	Example: find links to pdf files
+	MatchAttribute("href", Suffix(".pdf"))
+*/
+func MatchAttribute(key string, m Matcher) *AttributeValidator {
+	return &AttributeValidator{BaseValidator: &BaseValidator{}, Key: key, matcher: m}
+}
+
 func (t AttributeValidator) Validate(e Element) bool {
+	if t.matcher != nil {
+		val, ok := e.base().lookupAttribute(t.Key)
+		return ok && t.matcher.Match(val)
+	}
+
 	// Try to match the regex if present, otherwise assume plain string
 	if t.regex != nil {
 		if t.regex.MatchString(e.GetAttribute(t.Key)) {
@@ -130,3 +164,182 @@ func (t AttributeValidator) Validate(e Element) bool {
 	}
 	return false
 }
+
+// AndValidator matches elements which every one of its validators matches
+type AndValidator struct {
+	*BaseValidator
+	validators []Validator
+}
+
+/*
+	Combine validators so all of them must match
+	Example: find divs of the "result" class mentioning "kitty"
+	tag, _ := element.NewTagValidator("div")
+	class, _ := element.NewAttributeValidator("class", "result")
+	found := element.BFS(results, element.And(tag, class, element.TextContains("kitty")))
+*/
+func And(validators ...Validator) *AndValidator {
+	return &AndValidator{&BaseValidator{}, validators}
+}
+
+func (t AndValidator) Validate(e Element) bool {
+	for _, v := range t.validators {
+		if !v.Validate(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// OrValidator matches elements which any one of its validators matches
+type OrValidator struct {
+	*BaseValidator
+	validators []Validator
+}
+
+/*
+	Combine validators so any one of them can match
+	Example: find all headings and paragraphs
+	h, _ := element.NewTagValidator("h1")
+	p, _ := element.NewTagValidator("p")
+	element.Or(h, p)
+*/
+func Or(validators ...Validator) *OrValidator {
+	return &OrValidator{&BaseValidator{}, validators}
+}
+
+func (t OrValidator) Validate(e Element) bool {
+	for _, v := range t.validators {
+		if v.Validate(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotValidator matches elements its validator doesn't
+type NotValidator struct {
+	*BaseValidator
+	validator Validator
+}
+
+func Not(v Validator) *NotValidator {
+	return &NotValidator{&BaseValidator{}, v}
+}
+
+func (t NotValidator) Validate(e Element) bool {
+	return !t.validator.Validate(e)
+}
+
+// FuncValidator matches elements a custom predicate returns true for
+type FuncValidator struct {
+	*BaseValidator
+	fn func(Element) bool
+}
+
+/*
+	Use any function as a Validator
+	Example: find images without alt text
+	element.Func(func(e element.Element) bool {
+		return e.GetTagName() == "img" && e.GetAttribute("alt") == ""
+	})
+*/
+func Func(fn func(Element) bool) *FuncValidator {
+	return &FuncValidator{&BaseValidator{}, fn}
+}
+
+func (t FuncValidator) Validate(e Element) bool {
+	return t.fn(e)
+}
+
+// Match elements which have an attribute whatever its value
+func HasAttribute(key string) *FuncValidator {
+	return Func(func(e Element) bool {
+		_, ok := e.base().lookupAttribute(key)
+		return ok
+	})
+}
+
+/*
+	TextValidator checks the text of an element including the
+	text of all the elements below it
+*/
+type TextValidator struct {
+	*BaseValidator
+	wantedText string
+}
+
+// Match elements whose text contains the given text
+func TextContains(text string) *TextValidator {
+	return &TextValidator{&BaseValidator{Text: text}, text}
+}
+
+/*
+	Match elements whose text matches a go regular expression
+	Example: find prices
+	element.TextMatches(`\$[0-9]+\.[0-9]{2}`)
+*/
+func TextMatches(pattern string) (*TextValidator, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &TextValidator{&BaseValidator{Text: pattern, regex: regex}, pattern}, nil
+}
+
+func (t TextValidator) Validate(e Element) bool {
+	text := Text(e)
+	if t.regex != nil {
+		return t.regex.MatchString(text)
+	}
+	return strings.Contains(text, t.wantedText)
+}
+
+/*
+	ClassValidator matches elements by their class names, the class
+	attribute is split on white space and each name matched separately
+*/
+type ClassValidator struct {
+	*BaseValidator
+	matchers []Matcher
+}
+
+/*
+	Construct a ClassValidator matching elements with any class name
+	the Matcher accepts
+	Example: find elements with any "col-" class
+	MatchClass(Prefix("col-"))
+*/
+func MatchClass(m Matcher) *ClassValidator {
+	return &ClassValidator{&BaseValidator{}, []Matcher{m}}
+}
+
+/*
+	Construct a ClassValidator matching elements which have every one
+	of the given class names, in any order
+	Example: MatchClasses("btn", "btn-primary")
+*/
+func MatchClasses(classes ...string) *ClassValidator {
+	v := ClassValidator{&BaseValidator{}, []Matcher{}}
+	for _, class := range classes {
+		v.matchers = append(v.matchers, Exact(class))
+	}
+	return &v
+}
+
+func (t ClassValidator) Validate(e Element) bool {
+	classes := strings.Fields(e.GetAttribute("class"))
+	for _, m := range t.matchers {
+		found := false
+		for _, class := range classes {
+			if m.Match(class) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(classes) > 0
+}
@@ -0,0 +1,181 @@
+package element
+
+import (
+	"encoding/xml"
+	"github.com/tlowry/grawl/util"
+	"io"
+	"mime"
+	"strings"
+)
+
+/*
+	Build a Page from an xml document such as a feed, a SOAP response
+	or xhtml. Tags keep their case and namespace prefix as written,
+	Example: body := ParseXml(r).ByTag("soap:Body")
+*/
+func ParseXml(r io.Reader) *Page {
+	parser := NewParser()
+	parser.SetMode(PARSE_XML)
+	return parser.ParsePage(r)
+}
+
+// Does a Content-Type header describe an xml document
+func isXmlType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/xml" || mediaType == "text/xml" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+/*
+	Xml has no void or raw text tags so every element is ELEM_NORMAL
+	and CDATA sections become plain text. The decoder is lenient so
+	unknown html entities and unclosed tags don't stop the parse.
+*/
+func (p *Parser) parseXml(r io.Reader) *Page {
+	p.page = NewPage()
+	p.currentParent = nil
+
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = util.CharsetReader
+
+	for {
+		// RawToken leaves prefixes alone rather than swapping them for urls
+		token, err := decoder.RawToken()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			elem := buildXmlElement(t)
+			if p.page.root == nil {
+				p.page.root = elem
+			} else {
+				p.currentParent.AddChild(elem)
+			}
+			p.currentParent = elem
+			p.lastElement = elem
+		case xml.EndElement:
+			p.closeXmlElement(xmlName(t.Name))
+		case xml.CharData:
+			if p.currentParent != nil {
+				p.addText(string(t))
+			}
+		}
+	}
+
+	return p.page
+}
+
+// Close the nearest open element with this name, the root stays open
+func (p *Parser) closeXmlElement(tagName string) {
+	for open := p.currentParent; open != nil; open = open.GetParent() {
+		if open.GetTagName() == tagName {
+			if open != p.page.root {
+				p.currentParent = open.GetParent()
+			}
+			return
+		}
+	}
+}
+
+func buildXmlElement(token xml.StartElement) Element {
+	var newElem Element
+
+	// Forms in xhtml still want to be filled in
+	switch xmlName(token.Name) {
+	case "form":
+		newElem = NewForm()
+	case "input":
+		newElem = NewInput()
+	default:
+		newElem = NewBaseElement()
+	}
+
+	newElem.SetTagName(xmlName(token.Name))
+	newElem.SetKind(ELEM_NORMAL)
+
+	for _, attr := range token.Attr {
+		newElem.SetAttribute(xmlName(attr.Name), attr.Value)
+	}
+
+	return newElem
+}
+
+// The name as written, with its prefix if it has one
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// Return the tag name of an element without its namespace prefix
+func LocalName(e Element) string {
+	tag := e.GetTagName()
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[i+1:]
+	}
+	return tag
+}
+
+/*
+	Return the namespace url of an element, found from the xmlns
+	attributes on it and its ancestors. Empty if it has none.
+*/
+func NamespaceOf(e Element) string {
+	attr := "xmlns"
+	if i := strings.IndexByte(e.GetTagName(), ':'); i >= 0 {
+		attr = "xmlns:" + e.GetTagName()[:i]
+	}
+	for open := e; open != nil; open = open.GetParent() {
+		if ns, ok := open.base().lookupAttribute(attr); ok {
+			return ns
+		}
+	}
+	return ""
+}
+
+/*
+	NamespaceValidator matches elements by namespace url and local name,
+	so a document is searched the same whichever prefix it chose.
+*/
+type NamespaceValidator struct {
+	*BaseValidator
+	Namespace, Local string
+}
+
+/*
+	Construct a NamespaceValidator, an empty local name matches every
+	element in the namespace
+	Example: find every atom entry whatever its prefix
+	BFS(root, NewNamespaceValidator("http://www.w3.org/2005/Atom", "entry"))
+*/
+func NewNamespaceValidator(namespace, local string) *NamespaceValidator {
+	return &NamespaceValidator{BaseValidator: &BaseValidator{}, Namespace: namespace, Local: local}
+}
+
+func (n NamespaceValidator) Validate(e Element) bool {
+	if n.Local != "" && LocalName(e) != n.Local {
+		return false
+	}
+	return NamespaceOf(e) == n.Namespace
+}
+
+/*
+	Find the first element with this namespace url and local name
+	Example: body := page.ByNamespace("http://schemas.xmlsoap.org/soap/envelope/", "Body")
+*/
+func (p *Page) ByNamespace(namespace, local string) Element {
+	return BFSFirst(p.root, NewNamespaceValidator(namespace, local))
+}
+
+// Find all elements with this namespace url and local name
+func (p *Page) AllByNamespace(namespace, local string) []Element {
+	return BFS(p.root, NewNamespaceValidator(namespace, local))
+}
@@ -46,7 +46,7 @@ func main() {
 	page = conn.SubmitForm(form)
 	page.SaveToFile("afterForm.html")
 
-	results := page.AllByAttribute("class", "results_links results_links_deep web-result")
+	results := page.AllByClasses("results_links", "results_links_deep", "web-result")
 
 	var elem element.Element = nil
 	var snipText string
@@ -55,7 +55,7 @@ func main() {
 
 	// Look through the results page for anything to do with "Kitty"
 	for _, el := range results {
-		snippet := el.ByAttribute("class", "snippet")
+		snippet := el.ByClass("snippet")
 		snipText = snippet.GetContent()
 		if strings.Contains(snipText, "Kitty") {
 			elem = el
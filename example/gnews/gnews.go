@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/tlowry/grawl/browser"
+	"github.com/tlowry/grawl/element"
 	"log"
 )
 
@@ -16,9 +17,9 @@ func main() {
 	conn := browser.NewBrowser()
 	page := conn.Load("https://news.google.com/")
 
-	topSection := page.ByClass("section-stream-content*")
+	topSection := page.ByClass(element.Prefix("section-stream-content"))
 
-	stories := topSection.AllByClass("titletext*")
+	stories := topSection.AllByClass(element.Prefix("titletext"))
 
 	for _, story := range stories {
 		log.Println("Got story " + story.GetContent())
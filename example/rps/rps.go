@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/tlowry/grawl/browser"
+	"github.com/tlowry/grawl/element"
 	"github.com/tlowry/grawl/util"
 	"log"
 	"runtime/debug"
@@ -20,7 +21,7 @@ func main() {
 	page := conn.Load("rockpapershotgun.com")
 	//page.Absolutify()
 	page.SaveToFile("rps.html")
-	posts := page.AllById(`post-*[0-9]`)
+	posts := page.AllById(element.Regex(`post-*[0-9]`))
 
 	for _, post := range posts {
 
@@ -0,0 +1,442 @@
+/*
+	Package feed reads RSS 2.0, RSS 1.0 and Atom feeds into one model,
+	loading them through a grawl Browser so cookies, user agent and
+	proxies are shared with the pages being scraped.
+*/
+package feed
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/tlowry/grawl/browser"
+	"github.com/tlowry/grawl/element"
+	"github.com/tlowry/grawl/util"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_RSS2 = "rss2"
+	FORMAT_RSS1 = "rss1"
+	FORMAT_ATOM = "atom"
+)
+
+var ErrNotFeed = errors.New("grawl: not an RSS or Atom feed")
+
+// Sent when loading feeds, many servers only return a feed when asked for one
+const acceptFeeds = "application/rss+xml, application/atom+xml, application/rdf+xml;q=0.9, application/xml;q=0.8, text/xml;q=0.8, */*;q=0.5"
+
+type Feed struct {
+	// One of FORMAT_RSS2, FORMAT_RSS1 or FORMAT_ATOM
+	Format      string
+	Title       string
+	Link        string
+	Description string
+	Language    string
+	// Zero if the feed doesn't say
+	Updated time.Time
+	Items   []*Item
+}
+
+/*
+	An RSS item or Atom entry. Description is the summary and Content
+	the full text where the feed gives one, both are usually html.
+*/
+type Item struct {
+	Id          string
+	Title       string
+	Link        string
+	Description string
+	Content     string
+	Author      string
+	Published   time.Time
+	Updated     time.Time
+	Categories  []string
+	Enclosures  []Enclosure
+}
+
+// A file attached to an item, such as a podcast episode
+type Enclosure struct {
+	Url    string
+	Type   string
+	Length int64
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+/*
+	Load and parse a feed. Relative links in it are resolved
+	against the url the feed was finally loaded from.
+	Example:
+	news, err := feed.Load(b, "https://www.rockpapershotgun.com/feed")
+	for _, item := range news.Items {
+		log.Println(item.Title, item.Link)
+	}
+*/
+func Load(b *browser.Browser, feedUrl string) (*Feed, error) {
+	resp, err := b.Fetch(feedUrl, http.Header{"Accept": {acceptFeeds}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("grawl: loading feed %s: %s", feedUrl, resp.Status)
+	}
+
+	f, err := Parse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.resolve(resp.Request.URL)
+	return f, nil
+}
+
+/*
+	Return the RSS and Atom feeds a page links to with <link rel="alternate">,
+	best first as the page lists them. JSON feeds are left out as Parse
+	only reads xml, they are still in page.Metadata().Feeds.
+	Example:
+	if links := feed.Discover(page); len(links) > 0 {
+		news, err := feed.Load(b, links[0].Href)
+	}
+*/
+func Discover(page *element.Page) []element.Link {
+	links := []element.Link{}
+	for _, link := range page.Metadata().Feeds {
+		if link.Type == "application/rss+xml" || link.Type == "application/atom+xml" {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Parse a feed in any of the supported formats
+func Parse(r io.Reader) (*Feed, error) {
+	decoder := xml.NewDecoder(bufio.NewReader(r))
+	decoder.Strict = false
+	decoder.CharsetReader = util.CharsetReader
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, ErrNotFeed
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(start.Name.Local) {
+		case "rss":
+			return parseRss(decoder, start)
+		case "rdf":
+			return parseRdf(decoder, start)
+		case "feed":
+			return parseAtom(decoder, start)
+		}
+		return nil, fmt.Errorf("%w: starts with <%s>", ErrNotFeed, start.Name.Local)
+	}
+}
+
+// A link element which may be RSS (text) or Atom style (attributes)
+type xmlLink struct {
+	Href   string `xml:"href,attr"`
+	Url    string `xml:"url,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+	Text   string `xml:",chardata"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Links         []xmlLink `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	PubDate       string    `xml:"pubDate"`
+	Date          string    `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string    `xml:"title"`
+	Links       []xmlLink `xml:"link"`
+	Description string    `xml:"description"`
+	Content     string    `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Guid        string    `xml:"guid"`
+	About       string    `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	PubDate     string    `xml:"pubDate"`
+	Date        string    `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string    `xml:"author"`
+	Creator     string    `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string  `xml:"category"`
+	Subjects    []string  `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Enclosures  []xmlLink `xml:"enclosure"`
+}
+
+func parseRss(decoder *xml.Decoder, start xml.StartElement) (*Feed, error) {
+	doc := struct {
+		Channel rssChannel `xml:"channel"`
+	}{}
+	if err := decoder.DecodeElement(&doc, &start); err != nil {
+		return nil, err
+	}
+	return fromRss(FORMAT_RSS2, doc.Channel, doc.Channel.Items), nil
+}
+
+// RSS 1.0 is RDF, its items sit next to the channel rather than in it
+func parseRdf(decoder *xml.Decoder, start xml.StartElement) (*Feed, error) {
+	doc := struct {
+		Channel rssChannel `xml:"channel"`
+		Items   []rssItem  `xml:"item"`
+	}{}
+	if err := decoder.DecodeElement(&doc, &start); err != nil {
+		return nil, err
+	}
+	return fromRss(FORMAT_RSS1, doc.Channel, doc.Items), nil
+}
+
+func fromRss(format string, channel rssChannel, items []rssItem) *Feed {
+	f := Feed{
+		Format:      format,
+		Title:       clean(channel.Title),
+		Link:        rssLink(channel.Links),
+		Description: strings.TrimSpace(channel.Description),
+		Language:    clean(channel.Language),
+		Updated:     parseDate(channel.LastBuildDate, channel.PubDate, channel.Date),
+		Items:       make([]*Item, 0, len(items)),
+	}
+
+	for _, raw := range items {
+		item := Item{
+			Id:          firstOf(raw.Guid, raw.About),
+			Title:       clean(raw.Title),
+			Link:        rssLink(raw.Links),
+			Description: strings.TrimSpace(raw.Description),
+			Content:     strings.TrimSpace(raw.Content),
+			Author:      firstOf(raw.Creator, raw.Author),
+			Published:   parseDate(raw.PubDate, raw.Date),
+			Categories:  []string{},
+			Enclosures:  []Enclosure{},
+		}
+		for _, category := range append(raw.Categories, raw.Subjects...) {
+			if category = clean(category); category != "" {
+				item.Categories = append(item.Categories, category)
+			}
+		}
+		for _, enclosure := range raw.Enclosures {
+			item.Enclosures = append(item.Enclosures, toEnclosure(enclosure, "url"))
+		}
+		if item.Link == "" && strings.HasPrefix(item.Id, "http") {
+			// A permalink guid is the items link
+			item.Link = item.Id
+		}
+		f.Items = append(f.Items, &item)
+	}
+	return &f
+}
+
+// RSS links are text, but channels often carry an atom:link as well
+func rssLink(links []xmlLink) string {
+	for _, link := range links {
+		if text := clean(link.Text); text != "" {
+			return text
+		}
+	}
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return clean(link.Href)
+		}
+	}
+	return ""
+}
+
+// Atom text can be plain text, escaped html or inline xhtml
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email"`
+}
+
+type atomEntry struct {
+	Id         string       `xml:"id"`
+	Title      atomText     `xml:"title"`
+	Links      []xmlLink    `xml:"link"`
+	Summary    atomText     `xml:"summary"`
+	Content    atomText     `xml:"content"`
+	Authors    []atomPerson `xml:"author"`
+	Published  string       `xml:"published"`
+	Updated    string       `xml:"updated"`
+	Categories []struct {
+		Term  string `xml:"term,attr"`
+		Label string `xml:"label,attr"`
+	} `xml:"category"`
+}
+
+func parseAtom(decoder *xml.Decoder, start xml.StartElement) (*Feed, error) {
+	doc := struct {
+		Title    atomText     `xml:"title"`
+		Subtitle atomText     `xml:"subtitle"`
+		Links    []xmlLink    `xml:"link"`
+		Updated  string       `xml:"updated"`
+		Authors  []atomPerson `xml:"author"`
+		Lang     string       `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+		Entries  []atomEntry  `xml:"entry"`
+	}{}
+	if err := decoder.DecodeElement(&doc, &start); err != nil {
+		return nil, err
+	}
+
+	f := Feed{
+		Format:      FORMAT_ATOM,
+		Title:       clean(doc.Title.String()),
+		Link:        atomLink(doc.Links),
+		Description: strings.TrimSpace(doc.Subtitle.String()),
+		Language:    doc.Lang,
+		Updated:     parseDate(doc.Updated),
+		Items:       make([]*Item, 0, len(doc.Entries)),
+	}
+
+	for _, entry := range doc.Entries {
+		item := Item{
+			Id:          strings.TrimSpace(entry.Id),
+			Title:       clean(entry.Title.String()),
+			Link:        atomLink(entry.Links),
+			Description: strings.TrimSpace(entry.Summary.String()),
+			Content:     strings.TrimSpace(entry.Content.String()),
+			Published:   parseDate(entry.Published),
+			Updated:     parseDate(entry.Updated),
+			Categories:  []string{},
+			Enclosures:  []Enclosure{},
+		}
+		// Entries without an author inherit the feeds
+		authors := entry.Authors
+		if len(authors) == 0 {
+			authors = doc.Authors
+		}
+		if len(authors) > 0 {
+			item.Author = firstOf(authors[0].Name, authors[0].Email)
+		}
+		if item.Published.IsZero() {
+			item.Published = item.Updated
+		}
+		for _, category := range entry.Categories {
+			if name := clean(firstOf(category.Label, category.Term)); name != "" {
+				item.Categories = append(item.Categories, name)
+			}
+		}
+		for _, link := range entry.Links {
+			if link.Rel == "enclosure" {
+				item.Enclosures = append(item.Enclosures, toEnclosure(link, "href"))
+			}
+		}
+		f.Items = append(f.Items, &item)
+	}
+	return &f, nil
+}
+
+func atomLink(links []xmlLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return clean(link.Href)
+		}
+	}
+	return ""
+}
+
+// The text of an Atom text construct, xhtml is returned as markup without its wrapping div
+func (t atomText) String() string {
+	if t.Type != "xhtml" {
+		return t.Text
+	}
+	inner := strings.TrimSpace(t.Inner)
+	if strings.HasPrefix(inner, "<div") && strings.HasSuffix(inner, "</div>") {
+		inner = inner[strings.Index(inner, ">")+1 : len(inner)-len("</div>")]
+	}
+	return inner
+}
+
+// RSS enclosures keep the url in url=, Atom in href=
+func toEnclosure(link xmlLink, attr string) Enclosure {
+	enclosure := Enclosure{Url: clean(link.Href), Type: clean(link.Type)}
+	if attr == "url" {
+		enclosure.Url = clean(link.Url)
+	}
+	enclosure.Length, _ = strconv.ParseInt(strings.TrimSpace(link.Length), 10, 64)
+	return enclosure
+}
+
+// Resolve the links in the feed against the url it was loaded from
+func (f *Feed) resolve(base *url.URL) {
+	resolve := func(ref string) string {
+		if ref == "" {
+			return ""
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	f.Link = resolve(f.Link)
+	for _, item := range f.Items {
+		item.Link = resolve(item.Link)
+		for i := range item.Enclosures {
+			item.Enclosures[i].Url = resolve(item.Enclosures[i].Url)
+		}
+	}
+}
+
+// Parse the first of the values which is a date the feed formats use
+func parseDate(values ...string) time.Time {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tlowry/grawl/browser"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rss2 = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+	<title>Example  News</title>
+	<atom:link href="https://example.com/feed" rel="self"/>
+	<link>https://example.com/</link>
+	<description>All the news</description>
+	<lastBuildDate>Wed, 01 May 2024 10:00:00 +0000</lastBuildDate>
+	<item>
+		<title>First</title>
+		<link>/first</link>
+		<description>&lt;p&gt;Summary&lt;/p&gt;</description>
+		<content:encoded><![CDATA[<p>Full text</p>]]></content:encoded>
+		<guid isPermaLink="false">id-1</guid>
+		<pubDate>Tue, 30 Apr 2024 09:30:00 GMT</pubDate>
+		<dc:creator>Ada</dc:creator>
+		<category>go</category><category>feeds</category>
+		<enclosure url="/ep1.mp3" type="audio/mpeg" length="1234"/>
+	</item>
+	<item><title>Second</title><guid>https://example.com/second</guid></item>
+</channel>
+</rss>`
+
+const rss1 = `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel rdf:about="https://example.com/"><title>Old</title><link>https://example.com/</link></channel>
+<item rdf:about="https://example.com/a"><title>A</title><link>https://example.com/a</link><dc:date>2024-01-02T03:04:05Z</dc:date></item>
+</rdf:RDF>`
+
+const atom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">
+	<title type="text">Atom Blog</title>
+	<link href="https://blog.example/" rel="alternate"/>
+	<link href="https://blog.example/atom.xml" rel="self"/>
+	<updated>2024-05-01T00:00:00Z</updated>
+	<author><name>Grace</name></author>
+	<entry>
+		<title type="html">Tea &amp;amp; cake</title>
+		<link href="https://blog.example/tea"/>
+		<link rel="enclosure" href="https://blog.example/tea.jpg" type="image/jpeg"/>
+		<id>urn:tea</id>
+		<updated>2024-04-01T12:00:00Z</updated>
+		<summary>Short</summary>
+		<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Long</p></div></content>
+		<category term="food"/>
+	</entry>
+</feed>`
+
+func TestParse(t *testing.T) {
+	f, err := Parse(strings.NewReader(rss2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != FORMAT_RSS2 || f.Title != "Example News" || f.Link != "https://example.com/" || f.Updated.Day() != 1 {
+		t.Errorf("unexpected feed %+v", f)
+	}
+	first := f.Items[0]
+	if first.Id != "id-1" || first.Author != "Ada" || first.Content != "<p>Full text</p>" || first.Description != "<p>Summary</p>" {
+		t.Errorf("unexpected item %+v", first)
+	}
+	if first.Published.Hour() != 9 || strings.Join(first.Categories, ",") != "go,feeds" {
+		t.Errorf("unexpected date or categories %+v", first)
+	}
+	if len(first.Enclosures) != 1 || first.Enclosures[0].Length != 1234 || first.Enclosures[0].Type != "audio/mpeg" {
+		t.Errorf("unexpected enclosures %+v", first.Enclosures)
+	}
+	if f.Items[1].Link != "https://example.com/second" {
+		t.Errorf("expected a permalink guid to be used as the link, got %q", f.Items[1].Link)
+	}
+
+	f, err = Parse(strings.NewReader(rss1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != FORMAT_RSS1 || f.Title != "Old" || len(f.Items) != 1 || f.Items[0].Id != "https://example.com/a" || f.Items[0].Published.Year() != 2024 {
+		t.Errorf("unexpected RSS 1.0 feed %+v %+v", f, f.Items)
+	}
+
+	f, err = Parse(strings.NewReader(atom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := f.Items[0]
+	if f.Format != FORMAT_ATOM || f.Link != "https://blog.example/" || f.Language != "en" {
+		t.Errorf("unexpected atom feed %+v", f)
+	}
+	if entry.Title != "Tea &amp; cake" || entry.Link != "https://blog.example/tea" || entry.Author != "Grace" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.Content != "<p>Long</p>" || entry.Published.Month() != 4 || entry.Categories[0] != "food" || len(entry.Enclosures) != 1 {
+		t.Errorf("unexpected entry content %+v", entry)
+	}
+
+	if _, err := Parse(strings.NewReader("<html><body></body></html>")); !errors.Is(err, ErrNotFeed) {
+		t.Errorf("expected ErrNotFeed, got %v", err)
+	}
+}
+
+func TestLoadAndDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><head>
+<link rel="alternate" type="application/feed+json" href="/news/feed.json">
+<link rel="alternate" type="application/rss+xml" href="/news/feed">
+</head><body></body></html>`)
+		case "/news/feed":
+			if !strings.Contains(r.Header.Get("Accept"), "application/rss+xml") {
+				http.Error(w, "no feed accepted", http.StatusNotAcceptable)
+				return
+			}
+			fmt.Fprint(w, rss2)
+		}
+	}))
+	defer server.Close()
+
+	b := browser.NewBrowser()
+	page := b.Load(server.URL + "/")
+	links := Discover(page)
+	if len(links) != 1 {
+		t.Fatalf("expected only the rss feed link, got %v", links)
+	}
+
+	f, err := Load(b, links[0].Href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Items[0].Link != server.URL+"/first" || f.Items[0].Enclosures[0].Url != server.URL+"/ep1.mp3" {
+		t.Errorf("expected links resolved against the feed, got %+v", f.Items[0])
+	}
+}
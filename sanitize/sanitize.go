@@ -0,0 +1,245 @@
+/*
+	Package sanitize makes scraped html safe to show again by keeping
+	only the tags, attributes and urls a Policy allows.
+*/
+package sanitize
+
+import (
+	"github.com/tlowry/grawl/element"
+	"strings"
+)
+
+/*
+	A Policy decides what survives sanitizing.
+	Tags which aren't allowed are replaced by their children and text,
+	except for DropContent tags which are removed with everything in them.
+	Event handler attributes (on...) are always removed.
+*/
+type Policy struct {
+	// Tags to keep, by lower case name
+	Tags map[string]bool
+	// Attributes to keep by tag name, those under "*" are kept on every allowed tag
+	Attributes map[string]map[string]bool
+	// Schemes allowed in url attributes such as href and src
+	UrlSchemes map[string]bool
+	// Allow urls with no scheme, which are relative to the page showing them
+	AllowRelativeUrls bool
+	// Add rel="nofollow" to every link
+	Nofollow bool
+	// Tags removed along with their children and text
+	DropContent map[string]bool
+}
+
+// Attributes holding urls which have to pass the scheme check
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"formaction": true,
+	"href":       true,
+	"longdesc":   true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+	"xlink:href": true,
+}
+
+/*
+	Construct a Policy which allows nothing but text,
+	build on it with AllowTags, AllowAttributes and AllowUrlSchemes
+*/
+func NewPolicy() *Policy {
+	p := Policy{}
+	p.Tags = map[string]bool{}
+	p.Attributes = map[string]map[string]bool{}
+	p.UrlSchemes = map[string]bool{}
+	p.DropContent = map[string]bool{}
+	for _, tag := range []string{"script", "style", "head", "title", "template", "noscript",
+		"iframe", "object", "embed", "applet", "svg", "math", "textarea", "select", "frameset"} {
+		p.DropContent[tag] = true
+	}
+	return &p
+}
+
+/*
+	Construct a Policy suited to user generated content such as comments
+	and posts: text formatting, headings, lists, tables, quotes, code,
+	images and links to http, https and mailto urls, with nofollow added.
+	Example:
+	safe := sanitize.UGCPolicy().Sanitize(page.ById("comments"))
+*/
+func UGCPolicy() *Policy {
+	p := NewPolicy()
+	p.AllowTags("a", "abbr", "b", "blockquote", "br", "caption", "cite", "code", "col",
+		"colgroup", "dd", "del", "details", "div", "dl", "dt", "em", "figcaption", "figure",
+		"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "ins", "kbd", "li", "mark",
+		"ol", "p", "pre", "q", "s", "samp", "small", "span", "strike", "strong", "sub",
+		"summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "time", "tr", "u", "ul")
+	p.AllowAttributes("*", "title", "lang", "dir")
+	p.AllowAttributes("a", "href")
+	p.AllowAttributes("img", "src", "alt", "width", "height")
+	p.AllowAttributes("td", "colspan", "rowspan")
+	p.AllowAttributes("th", "colspan", "rowspan", "scope")
+	p.AllowAttributes("ol", "start")
+	p.AllowAttributes("blockquote", "cite")
+	p.AllowAttributes("q", "cite")
+	p.AllowAttributes("time", "datetime")
+	p.AllowUrlSchemes("http", "https", "mailto")
+	p.AllowRelativeUrls = true
+	p.Nofollow = true
+	return p
+}
+
+// Allow tags, they no longer have their content dropped
+func (p *Policy) AllowTags(tags ...string) *Policy {
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		p.Tags[tag] = true
+		delete(p.DropContent, tag)
+	}
+	return p
+}
+
+// Allow attributes on a tag, "*" allows them on every allowed tag
+func (p *Policy) AllowAttributes(tag string, attrs ...string) *Policy {
+	tag = strings.ToLower(tag)
+	if p.Attributes[tag] == nil {
+		p.Attributes[tag] = map[string]bool{}
+	}
+	for _, attr := range attrs {
+		p.Attributes[tag][strings.ToLower(attr)] = true
+	}
+	return p
+}
+
+func (p *Policy) AllowUrlSchemes(schemes ...string) *Policy {
+	for _, scheme := range schemes {
+		p.UrlSchemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+/*
+	Return the safe markup of an element and everything inside it.
+	The element itself is left unchanged, a copy is cleaned.
+	If the element's own tag isn't allowed only its content is returned.
+*/
+func (p *Policy) Sanitize(e element.Element) string {
+	clean := p.Clean(e)
+	if clean == nil {
+		return ""
+	}
+	if !p.Tags[clean.GetTagName()] {
+		return element.InnerHtml(clean)
+	}
+	return element.Html(clean)
+}
+
+/*
+	Return a cleaned copy of an element to work on further before writing it
+	out. Its own tag is kept whether it's allowed or not, nil if the
+	element's tag has its content dropped.
+*/
+func (p *Policy) Clean(e element.Element) element.Element {
+	if e == nil || p.DropContent[e.GetTagName()] {
+		return nil
+	}
+	clean := e.Clone()
+	p.cleanAttributes(clean)
+	p.cleanChildren(clean)
+	return clean
+}
+
+func (p *Policy) cleanChildren(e element.Element) {
+	for _, child := range e.GetChildren() {
+		tag := child.GetTagName()
+		switch {
+		case tag == "":
+			// Untagged elements only hold text
+		case p.DropContent[tag]:
+			child.Detach()
+		case !p.Tags[tag]:
+			p.cleanChildren(child)
+			child.Unwrap()
+		default:
+			p.cleanAttributes(child)
+			p.cleanChildren(child)
+		}
+	}
+}
+
+func (p *Policy) cleanAttributes(e element.Element) {
+	tag := e.GetTagName()
+	for key, value := range e.GetAttributes() {
+		name := strings.ToLower(key)
+		allowed := p.Attributes[tag][name] || p.Attributes["*"][name]
+		if strings.HasPrefix(name, "on") || !allowed {
+			e.RemoveAttribute(key)
+			continue
+		}
+		if urlAttributes[name] && !p.allowUrls(name, value) {
+			e.RemoveAttribute(key)
+		}
+	}
+
+	if tag == "a" && p.Nofollow && e.GetAttribute("href") != "" {
+		rel := strings.Fields(e.GetAttribute("rel"))
+		for _, r := range rel {
+			if strings.EqualFold(r, "nofollow") {
+				return
+			}
+		}
+		e.SetAttribute("rel", strings.Join(append(rel, "nofollow"), " "))
+	}
+}
+
+func (p *Policy) allowUrls(attr, value string) bool {
+	if attr != "srcset" {
+		return p.allowUrl(value)
+	}
+	// A srcset is a list of "url width" candidates
+	for _, candidate := range strings.Split(value, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 && !p.allowUrl(fields[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Check a url against the allowed schemes. Control characters and spaces
+	are removed first, as browsers ignore them in a scheme ("java\tscript:").
+*/
+func (p *Policy) allowUrl(raw string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	end := strings.IndexAny(cleaned, ":/?#")
+	if end < 0 || cleaned[end] != ':' {
+		return p.AllowRelativeUrls
+	}
+	scheme := strings.ToLower(cleaned[:end])
+	if !validScheme(scheme) {
+		return false
+	}
+	return p.UrlSchemes[scheme]
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i, ch := range scheme {
+		isLetter := ch >= 'a' && ch <= 'z'
+		isOther := (ch >= '0' && ch <= '9') || ch == '+' || ch == '-' || ch == '.'
+		if !isLetter && (i == 0 || !isOther) {
+			return false
+		}
+	}
+	return true
+}
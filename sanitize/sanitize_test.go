@@ -0,0 +1,63 @@
+package sanitize
+
+import (
+	"github.com/tlowry/grawl/element"
+	"strings"
+	"testing"
+)
+
+func parse(markup string) *element.Page {
+	return element.ParseBody(strings.NewReader("<html><body>" + markup + "</body></html>"))
+}
+
+func TestUGCPolicy(t *testing.T) {
+	page := parse(`<div id="post" onclick="steal()">
+<p class="x">Hello <b onmouseover="x()">world</b> &amp; <custom>friends</custom></p>
+<script>alert("hi")</script><style>p {}</style>
+<a href="javascript:alert(1)">bad</a> <a href="jav&#x09;ascript:alert(1)">tricky</a>
+<a href="/ok" rel="author">good</a> <a href="https://example.com">out</a>
+<img src="data:image/png;base64,AAAA" alt="a &quot;quote&quot;"><img src="/cat.png">
+<span title='"><script>'>kept</span>
+</div>`)
+
+	got := UGCPolicy().Sanitize(page.ById("post"))
+	want := []string{
+		`<div>`,
+		`<p>Hello <b>world</b> &amp; friends</p>`,
+		`<a>bad</a>`,
+		`<a>tricky</a>`,
+		`<a href="/ok" rel="nofollow">good</a>`,
+		`<a href="https://example.com" rel="nofollow">out</a>`,
+		`<img alt="a &quot;quote&quot;">`,
+		`<img src="/cat.png">`,
+		`<span title="&quot;&gt;&lt;script&gt;">kept</span>`,
+	}
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("expected %s in %s", w, got)
+		}
+	}
+	for _, bad := range []string{"onclick", "onmouseover", "alert", "p {}", "class=", "javascript"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("expected %q to be removed from %s", bad, got)
+		}
+	}
+
+	// The page itself is untouched
+	if page.ById("post").GetAttribute("onclick") == "" {
+		t.Errorf("expected the original element to be left alone")
+	}
+}
+
+func TestStrictPolicy(t *testing.T) {
+	page := parse(`<div id="post"><h1>Title</h1><p>1 &lt; 2 <i>really</i></p><script>x()</script></div>`)
+	if got := NewPolicy().Sanitize(page.ById("post")); got != "Title1 &lt; 2 really" {
+		t.Errorf("expected only escaped text, got %q", got)
+	}
+
+	custom := NewPolicy().AllowTags("p", "a").AllowAttributes("a", "href").AllowUrlSchemes("https")
+	page = parse(`<p id="post"><a href="http://example.com">plain</a> <a href="https://example.com">secure</a></p>`)
+	if got := custom.Sanitize(page.ById("post")); got != `<p><a>plain</a> <a href="https://example.com">secure</a></p>` {
+		t.Errorf("unexpected custom policy output %q", got)
+	}
+}
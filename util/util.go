@@ -1,7 +1,11 @@
 package util
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 var regexChars = `\.+*?()|[]{}^$`
@@ -31,3 +35,42 @@ func IsWhiteSpace(str string) bool {
 	// Reached the end without seeing a non-blank char
 	return true
 }
+
+/*
+	Windows-1252 characters for 0x80 to 0x9f, where latin-1 has control
+	codes. The five bytes windows-1252 leaves undefined keep their control
+	code, as browsers do.
+*/
+var windows1252 = [32]rune{
+	'\u20ac', '\u0081', '\u201a', '\u0192', '\u201e', '\u2026', '\u2020', '\u2021',
+	'\u02c6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008d', '\u017d', '\u008f',
+	'\u0090', '\u2018', '\u2019', '\u201c', '\u201d', '\u2022', '\u2013', '\u2014',
+	'\u02dc', '\u2122', '\u0161', '\u203a', '\u0153', '\u009d', '\u017e', '\u0178',
+}
+
+/*
+	A CharsetReader for encoding/xml decoders. Xml is meant to be utf-8
+	but latin-1 documents turn up, those are converted whole rather than
+	streamed. Like browsers, latin-1 is read as windows-1252 since the
+	control codes it has at 0x80 to 0x9f are really quotes, dashes and €.
+	Anything else is read as it is.
+*/
+func CharsetReader(charset string, in io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252":
+		latin, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 0, len(latin))
+		for _, ch := range latin {
+			if ch >= 0x80 && ch < 0xa0 {
+				out = utf8.AppendRune(out, windows1252[ch-0x80])
+			} else {
+				out = utf8.AppendRune(out, rune(ch))
+			}
+		}
+		return bytes.NewReader(out), nil
+	}
+	return in, nil
+}